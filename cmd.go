@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/recoilme/tgram/models"
+)
+
+// runCommand run maintenance command instead of server, example:
+// ./tgram migrate comments en ru
+// ./tgram migrate schema en ru
+// ./tgram migrate bans en ru
+// ./tgram reindex en ru
+// ./tgram check en
+// ./tgram export en en.jsonl
+// ./tgram import en en.jsonl
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		if len(args) < 3 {
+			return errors.New("usage: tgram migrate comments|schema|bans lang [lang...]")
+		}
+		switch args[1] {
+		case "comments":
+			for _, lang := range args[2:] {
+				articles, comments, err := models.CommentsMigrate(lang)
+				if err != nil {
+					return err
+				}
+				log.Printf("%s: moved %d comments from %d articles\n", lang, comments, articles)
+			}
+			return nil
+		case "schema":
+			for _, lang := range args[2:] {
+				migrated, err := models.RecordsMigrate(lang)
+				if err != nil {
+					return err
+				}
+				log.Printf("%s: migrated %d records\n", lang, migrated)
+			}
+			return nil
+		case "bans":
+			moved, err := models.BansMigrate(args[2:]...)
+			if err != nil {
+				return err
+			}
+			log.Printf("%v: moved %d bans\n", args[2:], moved)
+			return nil
+		}
+		return fmt.Errorf("unknown migration: %s", args[1])
+	case "check", "repair":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: tgram %s lang [lang...]", args[0])
+		}
+		repair := args[0] == "repair"
+		for _, lang := range args[1:] {
+			problems, err := models.Check(lang, repair)
+			if err != nil {
+				return err
+			}
+			for _, p := range problems {
+				log.Printf("%s: %s\n", lang, p)
+			}
+			if repair {
+				log.Printf("%s: fixed %d problems\n", lang, len(problems))
+			} else {
+				log.Printf("%s: found %d problems\n", lang, len(problems))
+			}
+		}
+		return nil
+	case "export":
+		if len(args) < 2 {
+			return errors.New("usage: tgram export lang [file]")
+		}
+		var w io.Writer = os.Stdout
+		if len(args) > 2 {
+			f, err := os.Create(args[2])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		n, err := models.Export(args[1], w)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: exported %d records\n", args[1], n)
+		return nil
+	case "import":
+		if len(args) < 2 {
+			return errors.New("usage: tgram import lang [file]")
+		}
+		var r io.Reader = os.Stdin
+		if len(args) > 2 {
+			f, err := os.Open(args[2])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		n, conflicts, err := models.Import(args[1], r)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: imported %d records\n", args[1], n)
+		if len(conflicts) > 0 {
+			log.Printf("%s: skipped records of %d users with taken names: %s\n", args[1], len(conflicts), strings.Join(conflicts, ", "))
+		}
+		return nil
+	case "reindex":
+		if len(args) < 2 {
+			return errors.New("usage: tgram reindex lang [lang...]")
+		}
+		for _, lang := range args[1:] {
+			indexed, err := models.SearchReindex(lang)
+			if err != nil {
+				return err
+			}
+			log.Printf("%s: indexed %d articles\n", lang, indexed)
+			tags, err := models.TagsRebuild(lang)
+			if err != nil {
+				return err
+			}
+			log.Printf("%s: counted %d tags\n", lang, tags)
+			ranked, err := models.RankRebuild(lang)
+			if err != nil {
+				return err
+			}
+			log.Printf("%s: ranked %d articles\n", lang, ranked)
+			refs, err := models.RefsRebuild(lang)
+			if err != nil {
+				return err
+			}
+			log.Printf("%s: indexed %d mentions, notifications and votes\n", lang, refs)
+			comments, err := models.CommentsRebuild(lang)
+			if err != nil {
+				return err
+			}
+			log.Printf("%s: indexed %d comments by thread\n", lang, comments)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown command: %s", args[0])
+}
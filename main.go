@@ -2,35 +2,49 @@ package main
 
 import (
 	"context"
+	"flag"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
 
-	"github.com/recoilme/slowpoke"
-
 	"github.com/joho/godotenv"
+	"github.com/recoilme/tgram/models"
 	"github.com/recoilme/tgram/routers"
 )
 
 // Port - typegram port (and address)
 var Port = ":8081"
 
+// DataRoot - directory with db, img and ava of this instance
+var DataRoot = "."
+
+// PublishEvery - how often scheduled drafts are checked
+var PublishEvery = time.Minute
+
+// PurgeEvery - how often trash is purged
+var PurgeEvery = time.Hour
+
+// RankEvery - how often top and bottom are recomputed
+var RankEvery = 5 * time.Minute
+
 // LoadEnv parse env file if present or load
 func LoadEnv() {
+	setifset := func(new, def string) string {
+		if new == "" {
+			return def
+		}
+		return new
+	}
 	err := godotenv.Load("tgram.env")
 	if err == nil {
-		setifset := func(new, def string) string {
-			if new == "" {
-				return def
-			}
-			return new
-		}
 		Port = setifset(os.Getenv("TGRAMPORT"), ":8081") //port with ":", example -  :8081
 		routers.Config.Title = setifset(os.Getenv("TGRAMTITLE"), "typegram")
 		routers.Config.SiteName = setifset(os.Getenv("TGRAMNAME"), "Typegram")
@@ -45,19 +59,87 @@ func LoadEnv() {
 		routers.Config.SMTPUser = setifset(os.Getenv("TGRAMSMTPUSER"), "")
 		routers.Config.SMTPPassword = setifset(os.Getenv("TGRAMSMTPPASS"), "")
 		routers.Config.FCMAuth = setifset(os.Getenv("TGRAMFCMAUTH"), "")
-
+	}
+	// instance settings also come from the environment without env file
+	DataRoot = setifset(os.Getenv("TGRAMROOT"), DataRoot)
+	if days, err := strconv.Atoi(os.Getenv("TGRAMTRASHDAYS")); err == nil && days > 0 {
+		models.TrashRetention = time.Duration(days) * 24 * time.Hour
+	}
+	// gravity of hot top: "1.8" for all languages or "en:1.8,ru:1.5"
+	for _, g := range strings.Split(os.Getenv("TGRAMGRAVITY"), ",") {
+		lang := ""
+		if i := strings.Index(g, ":"); i >= 0 {
+			lang, g = g[:i], g[i+1:]
+		}
+		if v, err := strconv.ParseFloat(strings.TrimSpace(g), 64); err == nil && v >= 0 {
+			models.RankGravity[strings.TrimSpace(lang)] = v
+		}
+	}
+	if os.Getenv("TGRAMSTORAGE") == "memory" {
+		// ephemeral instance, nothing stored on disk
+		models.SetStorage(models.NewMemStorage())
 	}
 }
 
 func main() {
 
 	LoadEnv()
+	// flag overrides env
+	flag.StringVar(&DataRoot, "root", DataRoot, "data root with db, img and ava dirs")
+	flag.Parse()
+	models.SetRoot(DataRoot)
+
+	// refuse database of newer version
+	if err := models.SchemaCheck(); err != nil {
+		models.Close()
+		log.Fatal(err)
+	}
+
+	if flag.NArg() > 0 {
+		err := runCommand(flag.Args())
+		if e := models.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// restore rate limits after restart
+	if _, err := models.LoadLimits(); err != nil {
+		log.Println("Load limits:", err)
+	}
 
 	srv := &http.Server{
 		Addr:    Port,
 		Handler: InitRouter(),
 	}
 
+	// purge old trash
+	go func() {
+		tick := time.Tick(PurgeEvery)
+		for {
+			if n := models.TrashPurge(time.Now()); n > 0 {
+				log.Println("Purged from trash:", n)
+			}
+			<-tick
+		}
+	}()
+
+	// publish scheduled drafts
+	go func() {
+		for range time.Tick(PublishEvery) {
+			routers.PublishScheduled()
+		}
+	}()
+
+	// recompute top, scores decay with time
+	go func() {
+		for range time.Tick(RankEvery) {
+			models.RankRefresh()
+		}
+	}()
+
 	go func() {
 		// service connections
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -67,7 +149,7 @@ func main() {
 
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 	log.Println("Shutdown Server ...")
@@ -78,7 +160,7 @@ func main() {
 		log.Fatal("Server Shutdown:", err)
 	}
 	// Close db
-	if err := slowpoke.CloseAll(); err != nil {
+	if err := models.Close(); err != nil {
 		log.Fatal("Database Shutdown:", err)
 	}
 	log.Println("Server exiting")
@@ -89,7 +171,7 @@ func globalRecover(c *gin.Context) {
 	defer func(c *gin.Context) {
 
 		if err := recover(); err != nil {
-			if err := slowpoke.CloseAll(); err != nil {
+			if err := models.Close(); err != nil {
 				log.Println("Database Shutdown err:", err)
 			}
 			log.Println("Server recovery with err:", err)
@@ -109,10 +191,15 @@ func InitRouter() *gin.Engine {
 	//r.Use(gin.Recovery())
 	//gin.DefaultWriter = ioutil.Discard
 
-	r.Use(static.Serve("/m", static.LocalFile("./media", false)))
-	r.Use(static.Serve("/i", static.LocalFile("./img", false))) //where i use it?
-	r.Use(static.Serve("/a", static.LocalFile("./ava", false)))
-	r.Use(static.Serve("/", static.LocalFile("./media/txt", false))) //for ssl cert
+	// media of data root overrides shared one
+	r.Use(static.Serve("/m", static.LocalFile(models.DataPath("media"), false)))
+	r.Use(static.Serve("/i", static.LocalFile(models.DataPath("img"), false))) //where i use it?
+	r.Use(static.Serve("/a", static.LocalFile(models.DataPath("ava"), false)))
+	r.Use(static.Serve("/", static.LocalFile(models.DataPath("media/txt"), false))) //for ssl cert
+	if models.DataPath("media") != "media" {
+		r.Use(static.Serve("/m", static.LocalFile("./media", false)))
+		r.Use(static.Serve("/", static.LocalFile("./media/txt", false)))
+	}
 
 	r.SetFuncMap(template.FuncMap{
 		"tostr":   routers.ToStr,
@@ -130,6 +217,9 @@ func InitRouter() *gin.Engine {
 	r.GET("/mid", routers.All)
 	r.GET("/top", routers.Top)
 	r.GET("/btm", routers.Btm)
+	r.GET("/search", routers.Search)
+	r.GET("/tags", routers.Tags)
+	r.GET("/tag/:name", routers.Tag)
 
 	r.GET("/register", routers.Register)
 	r.POST("/register", routers.Register)
@@ -139,6 +229,8 @@ func InitRouter() *gin.Engine {
 
 	r.GET("/@:username/:aid", routers.Article)
 	r.GET("/@:username", routers.Author)
+	r.GET("/revisions/@:username/:aid", routers.Revisions)
+	r.POST("/restore/@:username/:aid/:rev", routers.RevisionRestore)
 	r.GET("/a/:avatar", routers.Avatar)
 
 	r.GET("/favorites/@:username", routers.Favorites)
@@ -149,32 +241,63 @@ func InitRouter() *gin.Engine {
 	// only for registered users
 	r.Use(routers.GoToRegister())
 
+	r.GET("/feed", routers.Feed)
+	r.GET("/notifications", routers.Notifications)
+	r.POST("/notifications", routers.Notifications)
+
 	r.GET("/settings", routers.Settings)
 	r.POST("/settings", routers.Settings)
+	r.GET("/settings/export", routers.DataExport)
 
 	r.POST("/logout", routers.Logout)
 
 	r.GET("/delete/a/:aid", routers.ArticleDelete)
+	r.GET("/delete/u/:username", routers.AccountDelete)
+	r.POST("/delete/u/:username", routers.AccountDelete)
+	r.GET("/trash", routers.Trash)
+	r.POST("/trash/restore/@:author/:aid", routers.TrashRestore)
+	r.POST("/trash/purge/@:author/:aid", routers.TrashPurge)
 	r.GET("/bad/@:author/:aid/:bad", routers.ArticleBad)
 
 	r.GET("/editor/:aid", routers.Editor)
 	r.POST("/editor/:aid", routers.Editor)
 
+	r.GET("/drafts", routers.Drafts)
+	r.POST("/draft/del/:did", routers.DraftDel)
+	r.POST("/draft/publish/:did", routers.DraftPublish)
+
 	r.GET("follow/:user/*action", routers.Follow)
 	r.GET("unfollow/:user/*action", routers.Unfollow)
 
+	r.GET("block/:user/*action", routers.Block)
+	r.GET("unblock/:user/*action", routers.Unblock)
+	r.GET("mute/:user/*action", routers.Mute)
+	r.GET("unmute/:user/*action", routers.Unmute)
+
+	r.GET("tagfollow/:name/*action", routers.TagFollow)
+	r.GET("tagunfollow/:name/*action", routers.TagUnfollow)
+
 	r.GET("fav/:aid/*action", routers.Fav)
 	r.GET("unfav/:aid/*action", routers.Unfav)
 
+	r.GET("amute/:aid/*action", routers.ArticleMute)
+	r.GET("aunmute/:aid/*action", routers.ArticleUnmute)
+
 	r.GET("vote/:mode/@:author/:aid", routers.Vote)
 
 	r.POST("/comments/@:username/:aid", routers.CommentNew)
 	r.GET("/commentup/@:authorart/:authorcom/:aid/:cid", routers.CommentUp)
+	r.GET("/commentretract/@:authorart/:authorcom/:aid/:cid", routers.CommentRetract)
 	r.GET("/commentdel/@:authorart/:authorcom/:aid/:cid", routers.CommentDel)
 
 	r.GET("/upload", routers.Upload)
 	r.POST("/upload", routers.Upload)
 
+	r.GET("/bans", routers.Bans)
+	r.POST("/bans", routers.Bans)
+	r.POST("/unban", routers.Unban)
+	r.GET("/backup", routers.Backup)
+
 	r.GET("/export/type2tele", routers.Type2tele)
 	r.POST("/export/type2tele", routers.Type2tele)
 
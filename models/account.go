@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// UserDelete erase account of user: articles with drafts, trash and revisions,
+// comments, votes, follows, favorites, mentions, notifications and uploaded images
+// articles are purged through trash, so comments and favorites of them are removed too
+func UserDelete(lang, username string) (err error) {
+	if _, err = UserGet(lang, username); err != nil {
+		return errors.New("User not found")
+	}
+
+	// articles and drafts
+	fAUser := fmt.Sprintf(dbAUser, lang, username)
+	keys, _ := db.Keys(fAUser, nil, 0, 0, true)
+	for _, k := range keys {
+		if err = ArticleTrash(lang, username, BintoUint32(k), username); err != nil {
+			return err
+		}
+	}
+	for _, t := range Trash(lang, username) {
+		if err = ArticlePurge(lang, username, t.Article.ID); err != nil {
+			return err
+		}
+	}
+	for _, d := range Drafts(lang, username) {
+		if err = DraftDelete(lang, username, d.ID); err != nil {
+			return err
+		}
+	}
+
+	// comments of user on articles of others, cid - aid of all comments for votes
+	fCom := fmt.Sprintf(dbComment, lang)
+	cidAid := make(map[uint32]uint32)
+	cids := make(map[uint32]bool)
+	keys, _ = db.Keys(fCom, nil, 0, 0, true)
+	for _, k := range keys {
+		if len(k) != 8 {
+			continue
+		}
+		aid, cid := BintoUint32(k[:4]), BintoUint32(k[4:])
+		var c Article
+		if recordGet(fCom, k, schemaArticle, "", &c) != nil {
+			continue
+		}
+		if c.Author != username {
+			cidAid[cid] = aid
+			continue
+		}
+		if _, err = CommentDelete(lang, aid, cid); err != nil {
+			return err
+		}
+		cids[cid] = true
+	}
+	votesDelete(lang, "c", cids)
+
+	// votes of user, counters of articles and comments are decremented
+	prefix := append([]byte(username+":"), '*')
+	fAids := fmt.Sprintf(dbAids, lang)
+	fVote := fmt.Sprintf(dbVote, lang, "a")
+	keys, _ = db.Keys(fVote, prefix, 0, 0, true)
+	for _, k := range keys {
+		aid := BintoUint32(k[len(k)-4:])
+		if author, err := db.Get(fAids, k[len(k)-4:]); err == nil {
+			if _, err = ArticleVote(lang, username, string(author), aid, VoteRetract); err == nil {
+				continue
+			}
+		}
+		voteSet(lang, "a", username, aid, VoteRetract)
+	}
+	fVote = fmt.Sprintf(dbVote, lang, "c")
+	keys, _ = db.Keys(fVote, prefix, 0, 0, true)
+	for _, k := range keys {
+		cid := BintoUint32(k[len(k)-4:])
+		if aid, ok := cidAid[cid]; ok {
+			if _, err = CommentVote(lang, username, aid, cid, false); err == nil {
+				continue
+			}
+		}
+		voteSet(lang, "c", username, cid, VoteRetract)
+	}
+
+	// follows of user and followers, favorites, followed tags and muted articles of user, blocks and mutes
+	for _, cat := range []string{"fol", "fav", "tag", "blk", "mut", "amut"} {
+		keys, _ = db.Keys(fmt.Sprintf(dbSlaveMaster, lang, cat), prefix, 0, 0, true)
+		for _, k := range keys {
+			if err = Unfollowing(lang, cat, string(k[len(username)+1:]), username); err != nil {
+				return err
+			}
+		}
+	}
+	for _, cat := range []string{"fol", "blk", "mut"} {
+		keys, _ = db.Keys(fmt.Sprintf(dbMasterSlave, lang, cat), prefix, 0, 0, true)
+		for _, k := range keys {
+			if err = Unfollowing(lang, cat, username, string(k[len(username)+1:])); err != nil {
+				return err
+			}
+		}
+	}
+
+	// mentions to user and by user
+	fMention := fmt.Sprintf(dbMention, lang, username)
+	keys, _ = db.Keys(fMention, nil, 0, 0, true)
+	for _, k := range keys {
+		refRemove(lang, "m", username, k)
+	}
+	users, _ := db.Keys(fmt.Sprintf(dbUser, lang), nil, 0, 0, true)
+	for _, u := range users {
+		f := fmt.Sprintf(dbMention, lang, u)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			var m Mention
+			if err := db.GetGob(f, k, &m); err == nil && m.ByUsername == username {
+				refRemove(lang, "m", string(u), k)
+			}
+		}
+	}
+
+	notifyClear(lang, username)
+	notifyDelete(lang, func(n *Notification) bool { return n.By == username })
+	if err = imagesDelete(lang, username); err != nil {
+		return err
+	}
+	_, err = db.Delete(fmt.Sprintf(dbUser, lang), []byte(username))
+	return err
+}
+
+// imagesDelete remove uploaded images of user
+func imagesDelete(lang, username string) error {
+	dir := DataPath(filepath.Join("img", lang, username))
+	files, _ := filepath.Glob(filepath.Join(dir, "*.png"))
+	for _, f := range files {
+		if !imgName.MatchString(filepath.Base(f)) {
+			continue
+		}
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	db.Delete(fmt.Sprintf(dbImgID, lang, username), []byte("id"))
+	return nil
+}
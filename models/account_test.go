@@ -0,0 +1,95 @@
+package models_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestUserDelete(t *testing.T) {
+	defer useMemStorage()()
+	dir, err := ioutil.TempDir("", "tgram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	models.SetRoot(dir)
+	defer models.SetRoot(".")
+
+	for _, u := range []string{"alice", "bob"} {
+		if err := models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "alice article", Tag: "go"})
+	bobAid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "bob", Body: "bob article", Tag: "go"})
+	models.DraftSave("tst", "bob", &models.Draft{Article: models.Article{Body: "bob draft"}})
+	cid, _ := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "@alice hi"}, "alice", aid)
+	aliceCid, _ := models.CommentNew(&models.Article{Lang: "tst", Author: "alice", Body: "thanks"}, "alice", aid)
+	models.MentionNew("@alice hi", "tst", "hi", "bob", "/@alice/1", "/@alice/1#comment1", aid, cid)
+	models.ArticleVote("tst", "bob", "alice", aid, models.VoteUp)
+	models.CommentVote("tst", "bob", aid, aliceCid, true)
+	models.CommentVote("tst", "alice", aid, cid, true)
+	models.Following("tst", "fol", "alice", "bob")
+	models.Following("tst", "fol", "bob", "alice")
+	models.Following("tst", "fav", string(models.Uint32toBin(aid)), "bob")
+	img := filepath.Join(dir, "img", "tst", "bob", "1.png")
+	os.MkdirAll(filepath.Dir(img), 0755)
+	ioutil.WriteFile(img, []byte("png"), 0644)
+
+	if err := models.UserDelete("tst", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if models.UserExists("tst", "bob") {
+		t.Error("want user deleted")
+	}
+	if _, err := models.ArticleGet("tst", "bob", bobAid); err == nil {
+		t.Error("want articles deleted")
+	}
+	if len(models.Trash("tst", "bob")) != 0 || len(models.Drafts("tst", "bob")) != 0 {
+		t.Error("want trash and drafts deleted")
+	}
+	a, _ := models.ArticleGet("tst", "alice", aid)
+	if a.Plus != 0 {
+		t.Errorf("want vote retracted, got %d", a.Plus)
+	}
+	if c, _ := models.CommentGet("tst", aid, aliceCid); c.Plus != 0 {
+		t.Errorf("want comment vote retracted, got %d", c.Plus)
+	}
+	if models.CommentsCount("tst", aid) != 1 || len(models.Mentions("tst", "alice")) != 0 {
+		t.Error("want comments and mentions of user deleted")
+	}
+	if models.IsFollowing("tst", "fol", "bob", "alice") || models.FollowCount("tst", "fol", "alice") != 0 {
+		t.Error("want follows deleted")
+	}
+	if _, err := os.Stat(img); !os.IsNotExist(err) {
+		t.Error("want images deleted")
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+}
+
+func TestUserAuthReregistered(t *testing.T) {
+	defer useMemStorage()()
+
+	old := &models.User{Lang: "tst", Username: "bob", Password: "password"}
+	if err := models.UserNew(old); err != nil {
+		t.Fatal(err)
+	}
+	if !models.UserAuth("tst", "bob", old.Created) {
+		t.Fatal("want token of account valid")
+	}
+	models.UserDelete("tst", "bob")
+	if models.UserAuth("tst", "bob", old.Created) {
+		t.Error("want token of deleted account refused")
+	}
+	again := &models.User{Lang: "tst", Username: "bob", Password: "password"}
+	models.UserNew(again)
+	if models.UserAuth("tst", "bob", old.Created) || !models.UserAuth("tst", "bob", again.Created) {
+		t.Error("want only token of new account valid")
+	}
+}
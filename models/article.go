@@ -2,15 +2,10 @@ package models
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"html/template"
-	"math"
-	"sort"
 	"strconv"
 	"time"
-
-	sp "github.com/recoilme/slowpoke"
 )
 
 const (
@@ -31,14 +26,20 @@ type Article struct {
 	Image       string
 	OgImage     string `form:"ogimage" json:"ogimage" binding:"omitempty,url"`
 	CreatedAt   time.Time
+	UpdatedAt   time.Time // zero if never edited
 	Lang        string
 	HTML        template.HTML
 	Plus        uint32
 	Minus       uint32
-	Comments    []Article
+	Comments    []Article // loaded page, stored separately in dbComment
+	CommentCnt  int       // filled on read
+	Parent      uint32    `form:"parent" json:"parent,omitempty"` // comment replied to, 0 - top level
+	Deleted     bool      `form:"-" json:"deleted,omitempty"`     // tombstone of comment with replies
+	Depth       int       `form:"-" json:"-"`                     // nesting of comment in thread, filled on read
 	ReadingTime int
 	WordCount   int
-	Tag         string `form:"tag" json:"tag" binding:"omitempty,alphanum,max=20"`
+	Tag         string   `form:"tag" json:"tag" binding:"omitempty,max=120"` // first tag, tags separated by space in form
+	Tags        []string `form:"-" json:"tags"`
 }
 
 // Uint32toBin convert to binary
@@ -58,7 +59,7 @@ func ArticleNew(a *Article) (id uint32, err error) {
 	a.CreatedAt = time.Now()
 	fAid := fmt.Sprintf(dbAid, a.Lang)
 
-	aid, err := sp.Counter(fAid, []byte("aid"))
+	aid, err := db.Counter(fAid, []byte("aid"))
 	if err != nil {
 		return 0, err
 	}
@@ -66,79 +67,118 @@ func ArticleNew(a *Article) (id uint32, err error) {
 	id32 := Uint32toBin(a.ID)
 
 	fAids := fmt.Sprintf(dbAids, a.Lang)
-	if err = sp.Set(fAids, id32, []byte(a.Author)); err != nil {
+	if err = db.Set(fAids, id32, []byte(a.Author)); err != nil {
 		return 0, err
 	}
 
-	// tag
-	if a.Tag != "" {
-		fATag := fmt.Sprintf(dbATag, a.Lang, a.Tag)
-		sp.Set(fATag, id32, []byte(a.Author))
-	}
+	// tags
+	a.SetTags(a.TagList())
+	tagsCount(a.Lang, tagsSet(a, nil))
 
 	// uid
 	fAUser := fmt.Sprintf(dbAUser, a.Lang, a.Author)
 	// store
-	return a.ID, sp.SetGob(fAUser, id32, a)
+	if err = recordSet(fAUser, id32, schemaArticle, a); err != nil {
+		return 0, err
+	}
+	rankSync(a.Lang, a.ID)
+	return a.ID, SearchIndex(a)
 }
 
-// ArticleUpd update article
-func ArticleUpd(a *Article, oldTag string) (err error) {
-	// tag check
-	if a.Tag != oldTag {
-		if oldTag != "" {
-			//remove old tag
-			sp.Delete(fmt.Sprintf(dbATag, a.Lang, oldTag), Uint32toBin(a.ID))
+// ArticleUpd update article, must not be called under lock of article, see ArticleModify
+func ArticleUpd(a *Article, oldTags []string) (err error) {
+	counts, err := articleStore(a, oldTags)
+	tagsCount(a.Lang, counts)
+	rankSync(a.Lang, a.ID)
+	return err
+}
+
+// articleStore store article and its indexes, return changes of tag counters
+func articleStore(a *Article, oldTags []string) (counts map[string]int, err error) {
+	// tags check
+	a.SetTags(a.TagList())
+	counts = tagsSet(a, oldTags)
+	fAUser := fmt.Sprintf(dbAUser, a.Lang, a.Author)
+	if err = recordSet(fAUser, Uint32toBin(a.ID), schemaArticle, a); err != nil {
+		return counts, err
+	}
+	return counts, SearchIndex(a)
+}
+
+// ArticleModify atomically load article, apply fn and store it
+// fn may return error to cancel update
+func ArticleModify(lang, username string, aid uint32, fn func(a *Article) error) (a *Article, err error) {
+	a, counts, err := articleModify(lang, username, aid, fn)
+	// counters and ranking have own locks, so they are changed after article is unlocked
+	tagsCount(lang, counts)
+	rankSync(lang, aid)
+	return a, err
+}
+
+func articleModify(lang, username string, aid uint32, fn func(a *Article) error) (a *Article, counts map[string]int, err error) {
+	fAUser := fmt.Sprintf(dbAUser, lang, username)
+	unlock := lockKey(fAUser, Uint32toBin(aid))
+	defer unlock()
+
+	a, err = ArticleGet(lang, username, aid)
+	if err != nil {
+		return nil, nil, err
+	}
+	old := *a
+	if err = fn(a); err != nil {
+		return nil, nil, err
+	}
+	if contentChanged(&old, a) {
+		old.Lang = lang
+		if err = revisionNew(lang, &old); err != nil {
+			return nil, nil, err
 		}
-		//set new tag
-		sp.Set(fmt.Sprintf(dbATag, a.Lang, a.Tag), Uint32toBin(a.ID), []byte(a.Author))
+		a.UpdatedAt = time.Now()
 	}
-	fAUser := fmt.Sprintf(dbAUser, a.Lang, a.Author)
-	return sp.SetGob(fAUser, Uint32toBin(a.ID), a)
+	counts, err = articleStore(a, old.TagList())
+	return a, counts, err
 }
 
 // ArticleGet get article
 func ArticleGet(lang, username string, aid uint32) (a *Article, err error) {
 	fAUser := fmt.Sprintf(dbAUser, lang, username)
 
-	err = sp.GetGob(fAUser, Uint32toBin(aid), &a)
+	err = recordGet(fAUser, Uint32toBin(aid), schemaArticle, "", &a)
 	if err != nil {
 		return nil, err
 	}
 	return a, nil
 }
 
-// ArticleDelete delete article
-func ArticleDelete(lang, username string, aid uint32) (err error) {
-	fAUser := fmt.Sprintf(dbAUser, lang, username)
-	has, err := sp.Has(fAUser, Uint32toBin(aid))
-	if !has || err != nil {
-		return errors.New("Article not found")
-	}
-	_, err = sp.Delete(fAUser, Uint32toBin(aid))
+// ArticleAuthor return author of live article, "" if not found
+func ArticleAuthor(lang string, aid uint32) string {
+	author, err := db.Get(fmt.Sprintf(dbAids, lang), Uint32toBin(aid))
 	if err != nil {
-		return err
+		return ""
 	}
-	fAids := fmt.Sprintf(dbAids, lang)
-	sp.Delete(fAids, Uint32toBin(aid))
-	return nil
+	return string(author)
+}
+
+// ArticleDelete move article of author to trash
+func ArticleDelete(lang, username string, aid uint32) (err error) {
+	return ArticleTrash(lang, username, aid, username)
 }
 
 func ArticlesSelect(lang, fAids string, from []byte, limit, offset uint32, asc bool) (models []Article, first, last uint32, err error) {
-	keys, err := sp.Keys(fAids, from, limit, offset, asc)
+	keys, err := db.Keys(fAids, from, limit, offset, asc)
 	//log.Println(fAids, keys)
 	if err != nil {
 		return models, first, last, err
 	}
 	for _, key := range keys {
 		var model Article
-		uidb, err := sp.Get(fAids, key)
+		uidb, err := db.Get(fAids, key)
 		if err != nil {
 			//break
 			continue
 		}
 		fAUser := fmt.Sprintf(dbAUser, lang, string(uidb))
-		if err = sp.GetGob(fAUser, key, &model); err != nil {
+		if err = recordGet(fAUser, key, schemaArticle, "", &model); err != nil {
 			//break
 			continue
 		}
@@ -146,6 +186,7 @@ func ArticlesSelect(lang, fAids string, from []byte, limit, offset uint32, asc b
 			first = BintoUint32(key)
 		}
 		last = BintoUint32(key)
+		model.CommentCnt = CommentsCount(lang, model.ID)
 		models = append(models, model)
 	}
 	return models, first, last, err
@@ -169,17 +210,17 @@ func AllArticles(lang, from_str, tag string) (models []Article, page string, pre
 		fAids = fmt.Sprintf(dbATag, lang, tag)
 	}
 	models, firstkey, next, err := ArticlesSelect(lang, fAids, from, limit_int, uint32(0), false)
-	//all, _ := sp.Count(fAids)
+	//all, _ := db.Count(fAids)
 	page = fmt.Sprintf("%d..%d", firstkey, next)
 
 	// last article is prev to first article
 	//fAids := fmt.Sprintf(dbAids, lang)
-	lastkeys, _ := sp.Keys(fAids, nil, uint32(1), uint32(1), true)
+	lastkeys, _ := db.Keys(fAids, nil, uint32(1), uint32(1), true)
 	if len(lastkeys) > 0 {
 		last = BintoUint32(lastkeys[0])
 	}
 	// prev article
-	prevkeys, _ := sp.Keys(fAids, Uint32toBin(firstkey), uint32(1), limit_int, true)
+	prevkeys, _ := db.Keys(fAids, Uint32toBin(firstkey), uint32(1), limit_int, true)
 	if len(prevkeys) > 0 {
 		prev = BintoUint32(prevkeys[0])
 	}
@@ -190,54 +231,13 @@ func AllArticles(lang, from_str, tag string) (models []Article, page string, pre
 
 }
 
+// TopArticles return cnt best ("plus") or worst ("minus") articles by score with gravity
 func TopArticles(lang string, cnt uint32, by string) (models []Article, err error) {
-
-	models, _, _, err = ArticlesSelect(lang, fmt.Sprintf(dbAids, lang), nil, cnt*5, uint32(0), false)
-	if err != nil {
-		return models, err
+	models, _, err = Rank(lang, by, "", 0)
+	if len(models) > int(cnt) {
+		models = models[:cnt]
 	}
-	sorted, err := ArticlesSort(models, by, cnt)
-	return sorted, err
-}
-
-func ArticlesSort(models []Article, by string, cnt uint32) (sorted []Article, err error) {
-	type ArticleSort struct {
-		Article Article
-		Score   float64
-	}
-	now := time.Now()
-	var tmp []ArticleSort
-	for _, a := range models {
-		diff := now.Sub(a.CreatedAt)
-		hours := diff.Hours()
-		var vote float64
-		switch by {
-		case "minus":
-			vote = float64(a.Minus)
-		default:
-			vote = float64(a.Plus)
-		}
-		//https://medium.com/hacking-and-gonzo/how-hacker-news-ranking-algorithm-works-1d9b0cf2c08d
-		score := vote / (math.Pow(float64(hours+2), float64(1.8)))
-		//log.Println(a.Title, a.Plus, int(min), score)
-		var as ArticleSort
-		as.Article = a
-		as.Score = score
-		tmp = append(tmp, as)
-	}
-	sort.Slice(tmp, func(i, j int) bool {
-		return tmp[i].Score > tmp[j].Score
-	})
-
-	cur := 0
-	for _, nm := range tmp {
-		cur++
-		sorted = append(sorted, nm.Article)
-		if cur >= int(cnt) {
-			break
-		}
-	}
-	return sorted, err
+	return models, err
 }
 
 // ArticlesAuthor return articles by author
@@ -254,14 +254,14 @@ func ArticlesAuthor(lang, username, author, from_str string) (models []Article,
 	} else {
 		from = nil
 	}
-	keys, err := sp.Keys(fAUser, from, limit_int, uint32(0), true)
+	keys, err := db.Keys(fAUser, from, limit_int, uint32(0), true)
 	if err != nil {
 		return models, page, prev, next, last, err
 	}
 	for _, key := range keys {
 		var model Article
 
-		if err = sp.GetGob(fAUser, key, &model); err != nil {
+		if err = recordGet(fAUser, key, schemaArticle, "", &model); err != nil {
 			fmt.Println("kerr", err)
 			break
 		}
@@ -269,17 +269,18 @@ func ArticlesAuthor(lang, username, author, from_str string) (models []Article,
 			firstkey = BintoUint32(key)
 		}
 		next = BintoUint32(key)
+		model.CommentCnt = CommentsCount(lang, model.ID)
 		models = append(models, model)
 	}
-	//all, _ := sp.Count(fAUser)
+	//all, _ := db.Count(fAUser)
 	page = fmt.Sprintf("%d..%d", firstkey, next) //, all)
 	// last article is prev to last article
-	lastkeys, _ := sp.Keys(fAUser, nil, uint32(1), uint32(1), false)
+	lastkeys, _ := db.Keys(fAUser, nil, uint32(1), uint32(1), false)
 	if len(lastkeys) > 0 {
 		last = BintoUint32(lastkeys[0])
 	}
 	// prev article
-	prevkeys, _ := sp.Keys(fAUser, Uint32toBin(firstkey), uint32(1), limit_int, false)
+	prevkeys, _ := db.Keys(fAUser, Uint32toBin(firstkey), uint32(1), limit_int, false)
 	if len(prevkeys) > 0 {
 		prev = BintoUint32(prevkeys[0])
 	}
@@ -289,17 +290,17 @@ func ArticlesAuthor(lang, username, author, from_str string) (models []Article,
 		_, slavemaster := GetMasterSlave(author, username)
 		smf := fmt.Sprintf(dbSlaveMaster, lang, "fol")
 
-		has, err := sp.Has(smf, slavemaster)
+		has, err := db.Has(smf, slavemaster)
 		if err == nil && has {
-			b, _ := sp.Get(smf, slavemaster)
+			b, _ := db.Get(smf, slavemaster)
 			//log.Println("smf", next)
 			if len(b) == 4 {
 				lastSeen := BintoUint32(b)
 				if next > lastSeen {
-					sp.Set(smf, slavemaster, Uint32toBin(next))
+					db.Set(smf, slavemaster, Uint32toBin(next))
 				}
 			} else {
-				sp.Set(smf, slavemaster, Uint32toBin(next))
+				db.Set(smf, slavemaster, Uint32toBin(next))
 			}
 		}
 	}
@@ -309,31 +310,13 @@ func ArticlesAuthor(lang, username, author, from_str string) (models []Article,
 	return models, page, prev, next, last, err
 }
 
-// CommentNew create comment
-func CommentNew(a *Article, user string, mainaid uint32) (id uint32, err error) {
-	a.CreatedAt = time.Now()
-	fAid := fmt.Sprintf(dbAid, a.Lang)
-
-	aid, err := sp.Counter(fAid, []byte("cid"))
-	if err != nil {
-		return 0, err
-	}
-	a.ID = uint32(aid)
-	// uid
-	fAUser := fmt.Sprintf(dbAUser, a.Lang, user)
-	var maina Article
-	err = sp.GetGob(fAUser, Uint32toBin(mainaid), &maina)
-	if err != nil {
-		return 0, err
-	}
-	maina.Comments = append(maina.Comments, *a)
-	//var comments []Article
-	// store
-	return a.ID, sp.SetGob(fAUser, Uint32toBin(mainaid), maina)
-}
-
 // Favorites return 100 last Favorites
 func Favorites(lang, u string) (articles []Article) {
+	return favoritesSelect(lang, u, 100)
+}
+
+// favoritesSelect return limit (0 - all) last favorites
+func favoritesSelect(lang, u string, limit uint32) (articles []Article) {
 	cat := "fav"
 	//var err error
 	master32 := []byte(u)
@@ -342,7 +325,7 @@ func Favorites(lang, u string) (articles []Article) {
 	masterstar = append(masterstar, '*')
 	smf := fmt.Sprintf(dbSlaveMaster, lang, cat)
 
-	keys, _ := sp.Keys(smf, masterstar, uint32(100), 0, false)
+	keys, _ := db.Keys(smf, masterstar, limit, 0, false)
 	//log.Println("keys", keys)
 	lenU := len(u) + 1
 
@@ -350,12 +333,13 @@ func Favorites(lang, u string) (articles []Article) {
 	for _, k := range keys {
 		aid32 := k[lenU:]
 		//log.Println((aid32))
-		auser32, err := sp.Get(fAids, aid32)
+		auser32, err := db.Get(fAids, aid32)
 		//log.Println(string(auser32), err)
 		if err == nil {
 			var a Article
 			fAUser := fmt.Sprintf(dbAUser, lang, string(auser32))
-			if err := sp.GetGob(fAUser, aid32, &a); err == nil {
+			if err := recordGet(fAUser, aid32, schemaArticle, "", &a); err == nil {
+				a.CommentCnt = CommentsCount(lang, a.ID)
 				articles = append(articles, a)
 				//log.Println(a)
 			}
@@ -367,13 +351,13 @@ func Favorites(lang, u string) (articles []Article) {
 
 // ViewSet counter view by aid
 func ViewSet(lang string, aid uint32, v int) {
-	go sp.Set(fmt.Sprintf(dbView, lang), Uint32toBin(aid), Uint32toBin(uint32(v)))
+	go db.Set(fmt.Sprintf(dbView, lang), Uint32toBin(aid), Uint32toBin(uint32(v)))
 }
 
 // ViewGet return stored counter
 func ViewGet(lang string, aid uint32) (v int) {
 	v = 1
-	b, err := sp.Get(fmt.Sprintf(dbView, lang), Uint32toBin(aid))
+	b, err := db.Get(fmt.Sprintf(dbView, lang), Uint32toBin(aid))
 	if err == nil {
 		v = int(BintoUint32(b))
 	}
@@ -385,9 +369,9 @@ func DauSet(lang, ip string) {
 	t := time.Now()
 	year, month, day := t.Date()
 	stat := fmt.Sprintf(dbDau, lang, year, int(month), day)
-	has, _ := sp.Has(stat, []byte(ip))
+	has, _ := db.Has(stat, []byte(ip))
 	if !has {
-		go sp.Set(stat, []byte(ip), nil)
+		go db.Set(stat, []byte(ip), nil)
 	}
 }
 
@@ -396,7 +380,7 @@ func DauGet(lang string) int {
 	t := time.Now()
 	year, month, day := t.Date()
 	stat := fmt.Sprintf(dbDau, lang, year, int(month), day)
-	cnt, _ := sp.Count(stat)
+	cnt, _ := db.Count(stat)
 	return int(cnt)
 }
 
@@ -408,7 +392,7 @@ func WauGet(lang string) int {
 		year, month, day := t.Date()
 		stat := fmt.Sprintf(dbDau, lang, year, int(month), day)
 		//log.Println(stat)
-		keys, err := sp.Keys(stat, nil, uint32(0), uint32(0), true)
+		keys, err := db.Keys(stat, nil, uint32(0), uint32(0), true)
 		if err != nil {
 			continue
 		}
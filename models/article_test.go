@@ -0,0 +1,124 @@
+package models_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/recoilme/tgram/models"
+)
+
+// eachStorage run test on in-memory and on slowpoke storage in temp dir
+func eachStorage(t *testing.T, test func(t *testing.T)) {
+	t.Run("mem", func(t *testing.T) {
+		defer useMemStorage()()
+		test(t)
+	})
+	t.Run("slowpoke", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tgram")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		models.SetRoot(dir)
+		defer models.SetRoot(".")
+		old := models.GetStorage()
+		models.SetStorage(models.SlowpokeStorage{})
+		defer models.SetStorage(old)
+		defer models.Close()
+		test(t)
+	})
+}
+
+func TestConcurrentVotesAndComments(t *testing.T) {
+	eachStorage(t, func(t *testing.T) {
+		a := &models.Article{Lang: "tst", Author: "alice", Body: "article body"}
+		aid, err := models.ArticleNew(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cid, err := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "first"}, "alice", aid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const n = 50
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(4)
+			go func() {
+				defer wg.Done()
+				models.ArticleModify("tst", "alice", aid, func(a *models.Article) error {
+					a.Plus++
+					runtime.Gosched() // widen read-modify-write window
+					return nil
+				})
+			}()
+			go func() {
+				defer wg.Done()
+				models.ArticleModify("tst", "alice", aid, func(a *models.Article) error {
+					a.Minus++
+					runtime.Gosched()
+					return nil
+				})
+			}()
+			go func() {
+				defer wg.Done()
+				models.CommentModify("tst", aid, cid, func(c *models.Article) error {
+					c.Plus++
+					runtime.Gosched()
+					return nil
+				})
+			}()
+			go func() {
+				defer wg.Done()
+				models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "more"}, "alice", aid)
+			}()
+		}
+		wg.Wait()
+
+		a, err = models.ArticleGet("tst", "alice", aid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Plus != n || a.Minus != n {
+			t.Errorf("want %d:%d votes, got %d:%d", n, n, a.Plus, a.Minus)
+		}
+		c, err := models.CommentGet("tst", aid, cid)
+		if err != nil || c.Plus != n {
+			t.Errorf("want %d comment votes, got %+v (%v)", n, c, err)
+		}
+		if cnt := models.CommentsCount("tst", aid); cnt != n+1 {
+			t.Errorf("want %d comments, got %d", n+1, cnt)
+		}
+	})
+}
+
+// locks of different records must not block each other, article update
+// locks tag counters and ranking while article is locked
+func TestArticleModifyLocks(t *testing.T) {
+	defer useMemStorage()()
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "article body"})
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			models.ArticleModify("tst", "alice", aid, func(a *models.Article) error {
+				a.SetTags([]string{fmt.Sprintf("t%d", i)})
+				return nil
+			})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock on update of tags")
+	}
+	if tags := models.Tags("tst"); len(tags) != 1 || tags[0].Name != "t999" || tags[0].Count != 1 {
+		t.Errorf("want only last tag counted, got %v", tags)
+	}
+}
@@ -16,6 +16,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"github.com/recoilme/tgram/utils"
 )
 
 // Gender represents gender type
@@ -149,7 +151,12 @@ func randInt(rnd *rand.Rand, min int, max int) int {
 	return min + rnd.Intn(max-min)
 }
 
+// SaveToFile save image, relative path is resolved inside data root
 func SaveToFile(img image.Image, filePath string) error {
+	filePath = DataPath(filePath)
+	if _, err := utils.CheckAndCreate(filePath); err != nil {
+		return err
+	}
 	outFile, err := os.Create(filePath)
 	defer outFile.Close()
 	if err != nil {
@@ -0,0 +1,606 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ArchiveVersion - version of archive format, newer archives are rejected
+const ArchiveVersion = 1
+
+// archive line, Data depends on Type
+type archiveLine struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type archiveHeader struct {
+	Version int
+	Lang    string
+	Created time.Time
+}
+
+type archiveCounter struct {
+	Name  string
+	Value uint64
+}
+
+type archiveRevision struct {
+	Aid      uint32
+	Revision Revision
+}
+
+type archiveDraft struct {
+	Author string
+	Draft  Draft
+}
+
+type archiveComment struct {
+	Aid     uint32
+	Comment Article
+}
+
+type archiveVote struct {
+	Cat      string
+	Username string
+	ID       uint32
+	Dir      int
+	At       int64 `json:",omitempty"` // time of vote in ns, 0 - unknown
+}
+
+// archiveFollow - follow, block or mute of user, follow of tag, favorite or mute of article (Aid)
+type archiveFollow struct {
+	Slave  string
+	Master string `json:",omitempty"`
+	Tag    string `json:",omitempty"`
+	Aid    uint32 `json:",omitempty"`
+	Seen   uint32
+}
+
+type archiveMention struct {
+	Key     string
+	Mention Mention
+}
+
+// archiveNotify - notification of user
+type archiveNotify struct {
+	Username     string
+	Notification Notification
+}
+
+type archiveView struct {
+	Aid   uint32
+	Count uint32
+}
+
+// archiveCounters - id counters of language
+var archiveCounters = []string{"aid", "cid", "did", "nid"}
+
+// archiveWriter write records as json lines
+type archiveWriter struct {
+	enc *json.Encoder
+	n   int
+	err error
+}
+
+func (w *archiveWriter) write(typ string, data interface{}) {
+	if w.err != nil {
+		return
+	}
+	w.err = w.enc.Encode(struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}{typ, data})
+	w.n++
+}
+
+// Export write users, articles, comments, follows, favorites, mentions, notifications, votes, views
+// and counters of language to w as json lines, return count of records
+// records are written in order of dependencies: articles before comments and so on
+func Export(lang string, w io.Writer) (n int, err error) {
+	aw := &archiveWriter{enc: json.NewEncoder(w)}
+	aw.write("archive", archiveHeader{Version: ArchiveVersion, Lang: lang, Created: time.Now()})
+
+	fAid := fmt.Sprintf(dbAid, lang)
+	for _, name := range archiveCounters {
+		b, err := db.Get(fAid, []byte(name))
+		if err != nil || len(b) != 8 {
+			continue
+		}
+		aw.write("counter", archiveCounter{Name: name, Value: binary.BigEndian.Uint64(b)})
+	}
+
+	fUser := fmt.Sprintf(dbUser, lang)
+	users, err := db.Keys(fUser, nil, 0, 0, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range users {
+		user, err := userGet(lang, u)
+		if err != nil {
+			continue
+		}
+		aw.write("user", userRecord(*user))
+	}
+	for _, u := range users {
+		fAUser := fmt.Sprintf(dbAUser, lang, u)
+		keys, _ := db.Keys(fAUser, nil, 0, 0, true)
+		for _, k := range keys {
+			var a Article
+			if err := recordGet(fAUser, k, schemaArticle, "", &a); err != nil {
+				continue
+			}
+			aw.write("article", a)
+		}
+		fTrash := fmt.Sprintf(dbTrash, lang, u)
+		keys, _ = db.Keys(fTrash, nil, 0, 0, true)
+		for _, k := range keys {
+			var t Trashed
+			if err := recordGet(fTrash, k, schemaArticle, "Article", &t); err != nil {
+				continue
+			}
+			aw.write("trash", t)
+		}
+		for _, d := range Drafts(lang, string(u)) {
+			aw.write("draft", archiveDraft{Author: string(u), Draft: d})
+		}
+	}
+
+	fRev := fmt.Sprintf(dbRevision, lang)
+	keys, _ := db.Keys(fRev, nil, 0, 0, true)
+	for _, k := range keys {
+		var r Revision
+		if len(k) != 8 || recordGet(fRev, k, schemaArticle, "Article", &r) != nil {
+			continue
+		}
+		aw.write("revision", archiveRevision{Aid: BintoUint32(k[:4]), Revision: r})
+	}
+	fCom := fmt.Sprintf(dbComment, lang)
+	keys, _ = db.Keys(fCom, nil, 0, 0, true)
+	for _, k := range keys {
+		var c Article
+		if len(k) != 8 || recordGet(fCom, k, schemaArticle, "", &c) != nil {
+			continue
+		}
+		aw.write("comment", archiveComment{Aid: BintoUint32(k[:4]), Comment: c})
+	}
+	for _, cat := range []string{"a", "c"} {
+		f := fmt.Sprintf(dbVote, lang, cat)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			if len(k) < 5 {
+				continue
+			}
+			username, id := string(k[:len(k)-5]), BintoUint32(k[len(k)-4:])
+			if dir := voteGet(lang, cat, username, id); dir != VoteRetract {
+				aw.write("vote", archiveVote{Cat: cat, Username: username, ID: id, Dir: dir, At: voteAt(lang, cat, username, id)})
+			}
+		}
+	}
+
+	// slave:master - last seen, slave is username without ':'
+	for _, cat := range []string{"fol", "fav", "tag", "blk", "mut", "amut"} {
+		f := fmt.Sprintf(dbSlaveMaster, lang, cat)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			i := bytes.IndexByte(k, ':')
+			if i < 0 {
+				continue
+			}
+			fw := archiveFollow{Slave: string(k[:i])}
+			if b, err := db.Get(f, k); err == nil && len(b) == 4 {
+				fw.Seen = BintoUint32(b)
+			}
+			switch cat {
+			case "fol":
+				fw.Master = string(k[i+1:])
+				aw.write("follow", fw)
+				continue
+			case "tag":
+				fw.Tag = string(k[i+1:])
+				aw.write("tagfollow", fw)
+				continue
+			case "blk", "mut":
+				fw.Master = string(k[i+1:])
+				aw.write(map[string]string{"blk": "block", "mut": "mute"}[cat], fw)
+				continue
+			}
+			if len(k[i+1:]) != 4 {
+				continue
+			}
+			fw.Aid = BintoUint32(k[i+1:])
+			aw.write(map[string]string{"fav": "favorite", "amut": "amute"}[cat], fw)
+		}
+	}
+
+	for _, u := range users {
+		f := fmt.Sprintf(dbMention, lang, u)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			var key string
+			var m Mention
+			if gob.NewDecoder(bytes.NewReader(k)).Decode(&key) != nil || db.GetGob(f, k, &m) != nil {
+				continue
+			}
+			aw.write("mention", archiveMention{Key: key, Mention: m})
+		}
+		f = fmt.Sprintf(dbNotify, lang, u)
+		keys, _ = db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			var nt Notification
+			if recordGet(f, k, schemaNotify, "", &nt) != nil {
+				continue
+			}
+			aw.write("notification", archiveNotify{Username: string(u), Notification: nt})
+		}
+	}
+
+	fView := fmt.Sprintf(dbView, lang)
+	keys, _ = db.Keys(fView, nil, 0, 0, true)
+	for _, k := range keys {
+		b, err := db.Get(fView, k)
+		if len(k) != 4 || err != nil || len(b) != 4 {
+			continue
+		}
+		aw.write("view", archiveView{Aid: BintoUint32(k), Count: BintoUint32(b)})
+	}
+	return aw.n, aw.err
+}
+
+// importer restore archive records, ids are kept if language has no ids yet
+// otherwise new ids are allocated and references are remapped
+type importer struct {
+	lang    string
+	keep    map[string]bool   // counter name - ids are kept
+	max     map[string]uint64 // max kept or archived id
+	ids     map[string]map[uint32]uint32
+	authors map[uint32]string // new aid - author
+	users   map[string]bool   // imported users
+	// usernames of archive taken by local users, records of them are skipped
+	conflicts []string
+}
+
+func (im *importer) newID(name string, old uint32) (uint32, error) {
+	if im.keep[name] {
+		if uint64(old) > im.max[name] {
+			im.max[name] = uint64(old)
+		}
+		im.ids[name][old] = old
+		return old, nil
+	}
+	id, err := db.Counter(fmt.Sprintf(dbAid, im.lang), []byte(name))
+	if err != nil {
+		return 0, err
+	}
+	im.ids[name][old] = uint32(id)
+	return uint32(id), nil
+}
+
+// hasUser return true if user is imported, local user with same name may be another person
+func (im *importer) hasUser(username string) bool {
+	return im.users[username]
+}
+
+// Import restore archive written by Export into language, return count of imported records
+// and usernames of archive taken by existing users, records of them are skipped
+// existing users are kept, records of unknown users and articles are skipped
+// tag and search indexes are rebuilt after import
+func Import(lang string, r io.Reader) (n int, conflicts []string, err error) {
+	im := &importer{lang: lang, keep: make(map[string]bool), max: make(map[string]uint64),
+		ids: make(map[string]map[uint32]uint32), authors: make(map[uint32]string), users: make(map[string]bool)}
+	fAid := fmt.Sprintf(dbAid, lang)
+	for _, name := range archiveCounters {
+		has, _ := db.Has(fAid, []byte(name))
+		im.keep[name] = !has
+		im.ids[name] = make(map[uint32]uint32)
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var l archiveLine
+		if err = json.Unmarshal(sc.Bytes(), &l); err != nil {
+			return n, im.conflicts, fmt.Errorf("line %d: %v", line, err)
+		}
+		if line == 1 {
+			var h archiveHeader
+			if l.Type != "archive" || json.Unmarshal(l.Data, &h) != nil {
+				return n, im.conflicts, errors.New("Not an archive")
+			}
+			if h.Version > ArchiveVersion {
+				return n, im.conflicts, fmt.Errorf("Archive version %d is newer than supported %d", h.Version, ArchiveVersion)
+			}
+			continue
+		}
+		ok, err := im.restore(l)
+		if err != nil {
+			return n, im.conflicts, fmt.Errorf("line %d: %v", line, err)
+		}
+		if ok {
+			n++
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return n, im.conflicts, err
+	}
+	if line == 0 {
+		return 0, nil, errors.New("Not an archive")
+	}
+
+	for _, name := range archiveCounters {
+		if im.keep[name] && im.max[name] > 0 {
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, im.max[name])
+			if err = db.Set(fAid, []byte(name), b); err != nil {
+				return n, im.conflicts, err
+			}
+		}
+	}
+	if _, err = TagsRebuild(lang); err != nil {
+		return n, im.conflicts, err
+	}
+	if _, err = RankRebuild(lang); err != nil {
+		return n, im.conflicts, err
+	}
+	_, err = SearchReindex(lang)
+	return n, im.conflicts, err
+}
+
+// restore store one record, return false if record was skipped
+func (im *importer) restore(l archiveLine) (ok bool, err error) {
+	lang := im.lang
+	switch l.Type {
+	case "counter":
+		var c archiveCounter
+		if err = json.Unmarshal(l.Data, &c); err != nil {
+			return false, err
+		}
+		if im.keep[c.Name] && c.Value > im.max[c.Name] {
+			im.max[c.Name] = c.Value
+		}
+		return false, nil
+	case "user":
+		var u userRecord
+		if err = json.Unmarshal(l.Data, &u); err != nil {
+			return false, err
+		}
+		if u.Username == "" {
+			return false, nil
+		}
+		if has, _ := db.Has(fmt.Sprintf(dbUser, lang), []byte(u.Username)); has {
+			im.conflicts = append(im.conflicts, u.Username)
+			return false, nil
+		}
+		user := User(u)
+		user.Lang = lang
+		im.users[user.Username] = true
+		return true, userSet(&user)
+	case "article", "trash":
+		var t Trashed
+		a := &t.Article
+		if l.Type == "article" {
+			err = json.Unmarshal(l.Data, a)
+		} else {
+			err = json.Unmarshal(l.Data, &t)
+		}
+		if err != nil {
+			return false, err
+		}
+		if !im.hasUser(a.Author) {
+			return false, nil
+		}
+		if a.ID, err = im.newID("aid", a.ID); err != nil {
+			return false, err
+		}
+		a.Lang = lang
+		a.Comments = nil
+		im.authors[a.ID] = a.Author
+		id32 := Uint32toBin(a.ID)
+		if l.Type == "trash" {
+			if err = recordSet(fmt.Sprintf(dbTrash, lang, a.Author), id32, schemaArticle, t); err != nil {
+				return false, err
+			}
+			return true, db.Set(dbTrashQueue, trashQueueKey(lang, a.ID, t.DeletedAt), []byte(a.Author))
+		}
+		if err = db.Set(fmt.Sprintf(dbAids, lang), id32, []byte(a.Author)); err != nil {
+			return false, err
+		}
+		return true, recordSet(fmt.Sprintf(dbAUser, lang, a.Author), id32, schemaArticle, a)
+	case "draft":
+		var d archiveDraft
+		if err = json.Unmarshal(l.Data, &d); err != nil {
+			return false, err
+		}
+		if !im.hasUser(d.Author) {
+			return false, nil
+		}
+		if d.Draft.ID, err = im.newID("did", d.Draft.ID); err != nil {
+			return false, err
+		}
+		d.Draft.Article.Lang = lang
+		if err = recordSet(fmt.Sprintf(dbDraft, lang, d.Author), Uint32toBin(d.Draft.ID), schemaArticle, d.Draft); err != nil {
+			return false, err
+		}
+		if !d.Draft.PublishAt.IsZero() {
+			err = db.Set(dbSchedule, scheduleKey(lang, d.Draft.ID, d.Draft.PublishAt), []byte(d.Author))
+		}
+		return true, err
+	case "revision":
+		var r archiveRevision
+		if err = json.Unmarshal(l.Data, &r); err != nil {
+			return false, err
+		}
+		aid, found := im.ids["aid"][r.Aid]
+		if !found {
+			return false, nil
+		}
+		r.Revision.Article.ID = aid
+		r.Revision.Article.Lang = lang
+		return true, recordSet(fmt.Sprintf(dbRevision, lang), revisionKey(aid, r.Revision.Rev), schemaArticle, r.Revision)
+	case "comment":
+		var c archiveComment
+		if err = json.Unmarshal(l.Data, &c); err != nil {
+			return false, err
+		}
+		aid, found := im.ids["aid"][c.Aid]
+		// tombstone has no author, replies to skipped comment are kept on top level
+		if !found || !c.Comment.Deleted && !im.hasUser(c.Comment.Author) {
+			return false, nil
+		}
+		if c.Comment.ID, err = im.newID("cid", c.Comment.ID); err != nil {
+			return false, err
+		}
+		// parent is older and imported before reply
+		if c.Comment.Parent != 0 {
+			c.Comment.Parent = im.ids["cid"][c.Comment.Parent]
+		}
+		c.Comment.Lang = lang
+		if err = recordSet(fmt.Sprintf(dbComment, lang), commentKey(aid, c.Comment.ID), schemaArticle, c.Comment); err != nil {
+			return false, err
+		}
+		return true, db.Set(fmt.Sprintf(dbCommentTree, lang), treeKey(aid, c.Comment.Parent, c.Comment.ID), nil)
+	case "vote":
+		var v archiveVote
+		if err = json.Unmarshal(l.Data, &v); err != nil {
+			return false, err
+		}
+		name := map[string]string{"a": "aid", "c": "cid"}[v.Cat]
+		id, found := im.ids[name][v.ID]
+		if name == "" || !found || !im.hasUser(v.Username) {
+			return false, nil
+		}
+		return true, voteSetAt(lang, v.Cat, v.Username, id, v.Dir, v.At)
+	case "follow", "favorite", "tagfollow", "block", "mute", "amute":
+		var f archiveFollow
+		if err = json.Unmarshal(l.Data, &f); err != nil {
+			return false, err
+		}
+		cat, master := "fol", f.Master
+		switch l.Type {
+		case "favorite", "amute":
+			aid, found := im.ids["aid"][f.Aid]
+			if !found {
+				return false, nil
+			}
+			cat, master = map[string]string{"favorite": "fav", "amute": "amut"}[l.Type], string(Uint32toBin(aid))
+		case "tagfollow":
+			if tags, err := ParseTags(f.Tag); err != nil || len(tags) != 1 || tags[0] != f.Tag {
+				return false, nil
+			}
+			cat, master = "tag", f.Tag
+		default:
+			if !im.hasUser(master) {
+				return false, nil
+			}
+			if l.Type != "follow" {
+				cat = map[string]string{"block": "blk", "mute": "mut"}[l.Type]
+			}
+		}
+		// last seen is article id
+		if aid, found := im.ids["aid"][f.Seen]; found {
+			f.Seen = aid
+		}
+		if !im.hasUser(f.Slave) {
+			return false, nil
+		}
+		masterslave, slavemaster := GetMasterSlave(master, f.Slave)
+		if err = db.Set(fmt.Sprintf(dbMasterSlave, lang, cat), masterslave, nil); err != nil {
+			return false, err
+		}
+		return true, db.Set(fmt.Sprintf(dbSlaveMaster, lang, cat), slavemaster, Uint32toBin(f.Seen))
+	case "mention":
+		var m archiveMention
+		if err = json.Unmarshal(l.Data, &m); err != nil {
+			return false, err
+		}
+		aid, found := im.ids["aid"][m.Mention.Aid]
+		if !found || !im.hasUser(m.Mention.ToUsername) || !im.hasUser(m.Mention.ByUsername) {
+			return false, nil
+		}
+		if m.Mention.Cid != 0 {
+			cid, found := im.ids["cid"][m.Mention.Cid]
+			if !found {
+				return false, nil
+			}
+			m.Mention.Cid = cid
+		}
+		if !im.keep["aid"] || !im.keep["cid"] {
+			// links contain ids
+			m.Key = fmt.Sprintf("/@%s/%d", im.authors[aid], aid)
+			m.Mention.Path = m.Key
+			if m.Mention.Cid != 0 {
+				m.Mention.Path += fmt.Sprintf("#comment%d", m.Mention.Cid)
+			}
+		}
+		m.Mention.Aid = aid
+		if key, err := gobKey(m.Key); err == nil {
+			refAdd(lang, "m", aid, m.Mention.ToUsername, key)
+		}
+		return true, db.SetGob(fmt.Sprintf(dbMention, lang, m.Mention.ToUsername), m.Key, m.Mention)
+	case "notification":
+		var a archiveNotify
+		if err = json.Unmarshal(l.Data, &a); err != nil {
+			return false, err
+		}
+		nt := &a.Notification
+		if !im.hasUser(a.Username) || !im.hasUser(nt.By) {
+			return false, nil
+		}
+		if nt.Aid != 0 {
+			aid, found := im.ids["aid"][nt.Aid]
+			if !found {
+				return false, nil
+			}
+			if nt.Cid != 0 {
+				if nt.Cid, found = im.ids["cid"][nt.Cid]; !found {
+					return false, nil
+				}
+			}
+			if !im.keep["aid"] || !im.keep["cid"] {
+				// links contain ids
+				nt.Path = fmt.Sprintf("/@%s/%d", im.authors[aid], aid)
+				if nt.Cid != 0 {
+					nt.Path += fmt.Sprintf("#comment%d", nt.Cid)
+				}
+			}
+			nt.Aid = aid
+		}
+		if nt.ID, err = im.newID("nid", nt.ID); err != nil {
+			return false, err
+		}
+		id32 := Uint32toBin(nt.ID)
+		if err = recordSet(fmt.Sprintf(dbNotify, lang, a.Username), id32, schemaNotify, nt); err != nil {
+			return false, err
+		}
+		if nt.Aid != 0 {
+			refAdd(lang, "n", nt.Aid, a.Username, id32)
+		}
+		if nt.Read {
+			return true, nil
+		}
+		return true, db.Set(fmt.Sprintf(dbNotifyUnread, lang, a.Username), id32, nil)
+	case "view":
+		var v archiveView
+		if err = json.Unmarshal(l.Data, &v); err != nil {
+			return false, err
+		}
+		aid, found := im.ids["aid"][v.Aid]
+		if !found {
+			return false, nil
+		}
+		return true, db.Set(fmt.Sprintf(dbView, lang), Uint32toBin(aid), Uint32toBin(v.Count))
+	}
+	return false, fmt.Errorf("unknown record: %s", l.Type)
+}
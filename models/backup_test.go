@@ -0,0 +1,118 @@
+package models_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestExportImport(t *testing.T) {
+	defer useMemStorage()()
+
+	for _, u := range []string{"alice", "bob"} {
+		if err := models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Title: "Gophers", Body: "gophers everywhere", Tag: "go"})
+	gone, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "deleted article"})
+	models.ArticleDelete("tst", "alice", gone)
+	bobaid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "bob", Body: "bob article"})
+	thanks, _ := models.CommentNew(&models.Article{Lang: "tst", Author: "alice", Body: "@bob thanks"}, "bob", bobaid)
+	models.MentionNew("@bob thanks", "tst", "thanks", "alice", "/@bob/3", "/@bob/3#comment2", bobaid, thanks)
+	cid, _ := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "@alice nice"}, "alice", aid)
+	models.MentionNew("@alice nice", "tst", "nice", "bob", "/@alice/1", "/@alice/1#comment1", aid, cid)
+	models.CommentVote("tst", "alice", aid, cid, true)
+	models.ArticleVote("tst", "bob", "alice", aid, models.VoteUp)
+	models.Following("tst", "fol", "alice", "bob")
+	models.Following("tst", "fav", string(models.Uint32toBin(aid)), "bob")
+	models.FollowTag("tst", "go", "bob")
+	models.ViewSet("tst", aid, 7)
+	time.Sleep(10 * time.Millisecond) // ViewSet is async
+
+	var archive bytes.Buffer
+	n, err := models.Export("tst", &archive)
+	if err != nil || n < 10 {
+		t.Fatalf("want records exported, got %d %v", n, err)
+	}
+
+	// migrate to empty instance keeps ids
+	models.SetStorage(models.NewMemStorage())
+	if _, _, err = models.Import("tst", bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = models.UserCheckGet("tst", "bob", "password"); err != nil {
+		t.Errorf("want password kept, got %v", err)
+	}
+	a, err := models.ArticleGet("tst", "alice", aid)
+	if err != nil || a.Title != "Gophers" || a.Plus != 1 {
+		t.Fatalf("want article restored, got %+v %v", a, err)
+	}
+	if _, err = models.TrashGet("tst", "alice", gone); err != nil {
+		t.Error("want trash restored")
+	}
+	if models.CommentsCount("tst", aid) != 1 || !models.CommentVoteGet("tst", "alice", cid) ||
+		models.VoteGet("tst", "bob", aid) != models.VoteUp || models.ViewGet("tst", aid) != 7 {
+		t.Error("want comments, votes and views restored")
+	}
+	if !models.IsFollowing("tst", "fol", "alice", "bob") || len(models.Favorites("tst", "bob")) != 1 ||
+		len(models.Mentions("tst", "alice")) != 1 || !models.IsFollowing("tst", "tag", "go", "bob") {
+		t.Error("want follows, favorites and mentions restored")
+	}
+	if arts, _, _ := models.TagArticles("tst", "go", 0); len(arts) != 1 {
+		t.Error("want tag index rebuilt")
+	}
+	if arts, _, _ := models.Search("tst", "gophers", 0); len(arts) != 1 {
+		t.Error("want search index rebuilt")
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+	if newaid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "bob", Body: "after import"}); newaid <= gone {
+		t.Errorf("want counter after imported ids, got %d", newaid)
+	}
+
+	// merge renumbers articles, records of another local alice are skipped
+	models.SetStorage(models.NewMemStorage())
+	models.UserNew(&models.User{Lang: "tst", Username: "alice", Password: "another"})
+	local, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Title: "Local", Body: "local article"})
+	_, conflicts, err := models.Import("tst", bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0] != "alice" {
+		t.Errorf("want conflict reported, got %v", conflicts)
+	}
+	if _, err = models.UserCheckGet("tst", "alice", "another"); err != nil {
+		t.Errorf("want local user kept, got %v", err)
+	}
+	if a, err := models.ArticleGet("tst", "alice", local); err != nil || a.Title != "Local" {
+		t.Errorf("want local article kept, got %+v %v", a, err)
+	}
+	if arts, _, _ := models.TagArticles("tst", "go", 0); len(arts) != 0 {
+		t.Errorf("want articles of archived alice skipped, got %d", len(arts))
+	}
+	if a, err := models.ArticleGet("tst", "bob", local+1); err != nil || a.Body != "bob article" || a.ID == bobaid {
+		t.Errorf("want article of bob renumbered, got %+v %v", a, err)
+	}
+	if models.IsFollowing("tst", "fol", "alice", "bob") {
+		t.Error("want follow of local alice skipped")
+	}
+	if models.CommentsCount("tst", local+1) != 0 || len(models.Mentions("tst", "bob")) != 0 {
+		t.Error("want comment and mention of archived alice skipped")
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db after merge, got %v", problems)
+	}
+}
+
+func TestImportNewerArchive(t *testing.T) {
+	defer useMemStorage()()
+	_, _, err := models.Import("tst", strings.NewReader(`{"type":"archive","data":{"Version":999}}`))
+	if err == nil {
+		t.Error("want newer archive rejected")
+	}
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// uid:username or ip:address - Ban, usernames are per language
+	dbBan = "db/%s/ban"
+	// bans of all languages before language keyspaces, see BansMigrate
+	dbBanLegacy = "db/ban"
+
+	// BanTime - default ban duration
+	BanTime = 24 * time.Hour
+)
+
+// Ban of user or ip
+type Ban struct {
+	Target    string
+	IP        bool
+	Reason    string
+	Moderator string
+	CreatedAt time.Time
+	Until     time.Time // zero - forever
+}
+
+// Active return true if ban not expired
+func (b *Ban) Active() bool {
+	return b.Until.IsZero() || b.Until.After(time.Now())
+}
+
+// Kind return "ip" or "uid"
+func (b *Ban) Kind() string {
+	if b.IP {
+		return "ip"
+	}
+	return "uid"
+}
+
+// Error describe ban for banned user
+func (b *Ban) Error() string {
+	until := "forever"
+	if !b.Until.IsZero() {
+		until = "until " + b.Until.Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf("You are banned %s for: %s. Sorry about that(", until, b.Reason)
+}
+
+func banKey(target string, ip bool) []byte {
+	if ip {
+		return []byte("ip:" + target)
+	}
+	return []byte("uid:" + target)
+}
+
+// BanSet store ban in language, dur 0 - forever
+func BanSet(lang, target string, ip bool, reason, moderator string, dur time.Duration) (b *Ban, err error) {
+	if target == "" {
+		return nil, errors.New("Nothing to ban")
+	}
+	b = &Ban{Target: target, IP: ip, Reason: reason, Moderator: moderator, CreatedAt: time.Now()}
+	if dur > 0 {
+		b.Until = b.CreatedAt.Add(dur)
+	}
+	return b, db.SetGob(fmt.Sprintf(dbBan, lang), banKey(target, ip), b)
+}
+
+// BanGet return active ban in language or nil
+func BanGet(lang, target string, ip bool) *Ban {
+	if target == "" {
+		return nil
+	}
+	f := fmt.Sprintf(dbBan, lang)
+	var b Ban
+	if err := db.GetGob(f, banKey(target, ip), &b); err != nil {
+		return nil
+	}
+	if !b.Active() {
+		db.Delete(f, banKey(target, ip))
+		return nil
+	}
+	return &b
+}
+
+// UserBanGet return active ban of username or nil
+func UserBanGet(lang, username string) *Ban {
+	return BanGet(lang, username, false)
+}
+
+// IPBanGet return active ban of ip or nil
+func IPBanGet(lang, ip string) *Ban {
+	return BanGet(lang, ip, true)
+}
+
+// BanLift remove ban
+func BanLift(lang, target string, ip bool) error {
+	f := fmt.Sprintf(dbBan, lang)
+	has, err := db.Has(f, banKey(target, ip))
+	if !has || err != nil {
+		return errors.New("Ban not found")
+	}
+	_, err = db.Delete(f, banKey(target, ip))
+	return err
+}
+
+// Bans return active bans of language, newest first, and remove expired
+func Bans(lang string) (bans []Ban) {
+	f := fmt.Sprintf(dbBan, lang)
+	keys, _ := db.Keys(f, nil, 0, 0, true)
+	for _, k := range keys {
+		var b Ban
+		if err := db.GetGob(f, k, &b); err != nil {
+			continue
+		}
+		if !b.Active() {
+			db.Delete(f, k)
+			continue
+		}
+		bans = append(bans, b)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].CreatedAt.After(bans[j].CreatedAt)
+	})
+	return bans
+}
+
+// BansMigrate copy active bans of all languages to each of langs and remove them
+// return count of moved bans
+func BansMigrate(langs ...string) (moved int, err error) {
+	if len(langs) == 0 {
+		return 0, errors.New("No languages")
+	}
+	keys, err := db.Keys(dbBanLegacy, nil, 0, 0, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		var b Ban
+		if err = db.GetGob(dbBanLegacy, k, &b); err == nil && b.Active() {
+			for _, lang := range langs {
+				if err = db.SetGob(fmt.Sprintf(dbBan, lang), k, &b); err != nil {
+					return moved, err
+				}
+			}
+			moved++
+		}
+		db.Delete(dbBanLegacy, k)
+	}
+	return moved, nil
+}
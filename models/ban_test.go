@@ -0,0 +1,88 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestBans(t *testing.T) {
+	defer useMemStorage()()
+
+	if _, err := models.BanSet("tst", "alice", false, "spam", "admin", models.BanTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.BanSet("tst", "10.0.0.1", true, "spam", "admin", 0); err != nil {
+		t.Fatal(err)
+	}
+	if b := models.UserBanGet("tst", "alice"); b == nil || b.Moderator != "admin" {
+		t.Errorf("want ban of alice, got %v", b)
+	}
+	if b := models.IPBanGet("tst", "10.0.0.1"); b == nil || !b.Until.IsZero() {
+		t.Errorf("want forever ban of ip, got %v", b)
+	}
+	if b := models.UserBanGet("tst", "10.0.0.1"); b != nil {
+		t.Errorf("ip ban must not ban user, got %v", b)
+	}
+	if bans := models.Bans("tst"); len(bans) != 2 || bans[0].Target != "10.0.0.1" {
+		t.Errorf("want 2 bans newest first, got %v", bans)
+	}
+	if b := models.UserBanGet("en", "alice"); b != nil {
+		t.Errorf("want ban in its language only, got %v", b)
+	}
+
+	if err := models.BanLift("tst", "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	if b := models.UserBanGet("tst", "alice"); b != nil {
+		t.Errorf("want lifted ban, got %v", b)
+	}
+	if err := models.BanLift("tst", "alice", false); err == nil {
+		t.Error("want error on lifting missing ban")
+	}
+
+	models.BanSet("tst", "bob", false, "spam", "admin", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if b := models.UserBanGet("tst", "bob"); b != nil {
+		t.Errorf("want expired ban, got %v", b)
+	}
+	if bans := models.Bans("tst"); len(bans) != 1 {
+		t.Errorf("want expired bans purged, got %v", bans)
+	}
+}
+
+func TestLimitsSurviveRestart(t *testing.T) {
+	defer useMemStorage()()
+
+	models.PostLimitSet("tst", "alice")
+	if models.PostLimitGet("tst", "alice") == 0 {
+		t.Fatal("want post limit")
+	}
+	models.PostLimitDel("tst", "alice")
+	models.PostLimitSet("tst", "bob")
+	if _, err := models.LoadLimits(); err != nil {
+		t.Fatal(err)
+	}
+	if models.PostLimitGet("tst", "bob") == 0 {
+		t.Error("want post limit restored")
+	}
+	if models.PostLimitGet("tst", "alice") != 0 {
+		t.Error("want deleted limit not restored")
+	}
+}
+
+func TestBansMigrate(t *testing.T) {
+	defer useMemStorage()()
+
+	models.GetStorage().SetGob("db/ban", []byte("uid:alice"), &models.Ban{Target: "alice", Reason: "spam"})
+	if moved, err := models.BansMigrate("en", "ru"); err != nil || moved != 1 {
+		t.Fatalf("want 1 ban moved, got %d %v", moved, err)
+	}
+	if models.UserBanGet("en", "alice") == nil || models.UserBanGet("ru", "alice") == nil {
+		t.Error("want ban in each language")
+	}
+	if moved, _ := models.BansMigrate("en"); moved != 0 {
+		t.Errorf("want old bans removed, got %d", moved)
+	}
+}
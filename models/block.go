@@ -0,0 +1,80 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Block block ("blk") or mute ("mut") other user for user, stored as follow edges
+// blocked user can't comment articles of user, mention or follow user,
+// articles and comments of muted user are hidden from user
+func Block(lang, cat, username, other string) (err error) {
+	if cat != "blk" && cat != "mut" {
+		return errors.New("Not implemented")
+	}
+	if other == username {
+		return errors.New("You can't block yourself")
+	}
+	if !UserExists(lang, other) {
+		return errors.New("User not found")
+	}
+	if err = Following(lang, cat, other, username); err != nil {
+		return err
+	}
+	if cat == "blk" && IsFollowing(lang, "fol", username, other) {
+		return Unfollowing(lang, "fol", username, other)
+	}
+	return nil
+}
+
+// Unblock remove other user from block or mute list of user
+func Unblock(lang, cat, username, other string) (err error) {
+	if cat != "blk" && cat != "mut" {
+		return errors.New("Not implemented")
+	}
+	return Unfollowing(lang, cat, other, username)
+}
+
+// IsBlocked return true if user blocked other user
+func IsBlocked(lang, username, other string) bool {
+	if username == "" || other == "" {
+		return false
+	}
+	return IsFollowing(lang, "blk", other, username)
+}
+
+// Blocked return users blocked ("blk") or muted ("mut") by user, sorted
+func Blocked(lang, cat, username string) (users []string) {
+	if username == "" {
+		return users
+	}
+	smf := fmt.Sprintf(dbSlaveMaster, lang, cat)
+	keys, _ := db.Keys(smf, append([]byte(username+":"), '*'), 0, 0, true)
+	for _, k := range keys {
+		users = append(users, string(k[len(username)+1:]))
+	}
+	return users
+}
+
+// Muted return set of users muted by user
+func Muted(lang, username string) map[string]bool {
+	muted := make(map[string]bool)
+	for _, u := range Blocked(lang, "mut", username) {
+		muted[u] = true
+	}
+	return muted
+}
+
+// Unmuted return articles or comments without muted authors
+func Unmuted(articles []Article, muted map[string]bool) []Article {
+	if len(muted) == 0 {
+		return articles
+	}
+	res := articles[:0:0]
+	for _, a := range articles {
+		if !muted[a.Author] {
+			res = append(res, a)
+		}
+	}
+	return res
+}
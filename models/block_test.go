@@ -0,0 +1,61 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestBlock(t *testing.T) {
+	defer useMemStorage()()
+	for _, u := range []string{"alice", "bob", "carol"} {
+		models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"})
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "alice article"})
+	models.Following("tst", "fol", "alice", "bob")
+
+	if err := models.Block("tst", "blk", "alice", "alice"); err == nil {
+		t.Error("want self block refused")
+	}
+	if err := models.Block("tst", "blk", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if models.IsFollowing("tst", "fol", "alice", "bob") {
+		t.Error("want blocked user unfollowed")
+	}
+	if err := models.Following("tst", "fol", "alice", "bob"); err == nil {
+		t.Error("want blocked user can't follow")
+	}
+	if _, err := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "hi"}, "alice", aid); err == nil {
+		t.Error("want blocked user can't comment")
+	}
+	if m := models.MentionNew("@alice @carol", "tst", "hi", "bob", "/@bob/1", "/@bob/1", 1, 0); len(m) != 1 || m[0].ToUsername != "carol" {
+		t.Errorf("want blocked user can't mention, got %+v", m)
+	}
+	if _, err := models.CommentNew(&models.Article{Lang: "tst", Author: "carol", Body: "hi"}, "alice", aid); err != nil {
+		t.Error(err)
+	}
+
+	models.Block("tst", "mut", "bob", "carol")
+	comments, _, _ := models.Comments("tst", aid, 0)
+	if c := models.Unmuted(comments, models.Muted("tst", "bob")); len(c) != 0 {
+		t.Errorf("want comments of muted user hidden, got %v", c)
+	}
+	models.Following("tst", "fol", "carol", "bob")
+	models.ArticleNew(&models.Article{Lang: "tst", Author: "carol", Body: "carol article"})
+	if articles, _, _ := models.Feed("tst", "bob", 0); len(articles) != 0 {
+		t.Errorf("want muted user out of feed, got %v", articles)
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+
+	models.Unblock("tst", "blk", "alice", "bob")
+	if err := models.Following("tst", "fol", "alice", "bob"); err != nil {
+		t.Errorf("want unblocked user can follow, got %v", err)
+	}
+	models.UserDelete("tst", "bob")
+	if len(models.Blocked("tst", "mut", "bob")) != 0 || models.FollowCount("tst", "mut", "carol") != 0 {
+		t.Error("want mutes deleted with user")
+	}
+}
@@ -13,6 +13,9 @@ var (
 )
 
 const (
+	// persisted rate limits and vote budgets, key - cache key
+	dbRate = "db/rate"
+
 	RateIP      = 10 * time.Minute
 	RatePost    = 5 * time.Minute
 	RateComment = 30 * time.Second
@@ -24,12 +27,63 @@ const (
 	VoteArtMax = 10
 )
 
+// rateItem - cache item stored in dbRate
+type rateItem struct {
+	Value   int64
+	Counter bool
+	Expire  time.Time
+}
+
 func init() {
 	cc = cache.New(24*time.Hour, 10*time.Minute)
 }
 
+// LoadLimits restore rate limits and vote budgets after restart
+// and remove expired records
+func LoadLimits() (loaded int, err error) {
+	keys, err := db.Keys(dbRate, nil, 0, 0, true)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for _, k := range keys {
+		var item rateItem
+		if err := db.GetGob(dbRate, k, &item); err != nil || !item.Expire.After(now) {
+			db.Delete(dbRate, k)
+			continue
+		}
+		if item.Counter {
+			cc.Set(string(k), int(item.Value), item.Expire.Sub(now))
+		} else {
+			cc.Set(string(k), item.Value, item.Expire.Sub(now))
+		}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// limitSet store current time by key in cache and db
+func limitSet(key string) {
+	now := time.Now()
+	cc.Set(key, now.Unix(), cache.DefaultExpiration)
+	db.SetGob(dbRate, []byte(key), rateItem{Value: now.Unix(), Expire: now.Add(24 * time.Hour)})
+}
+
+// limitDel remove key from cache and db
+func limitDel(key string) {
+	cc.Delete(key)
+	db.Delete(dbRate, []byte(key))
+}
+
+// counterStore persist counter from cache with its expiration
+func counterStore(key string) {
+	if val, exp, found := cc.GetWithExpiration(key); found {
+		db.SetGob(dbRate, []byte(key), rateItem{Value: int64(val.(int)), Counter: true, Expire: exp})
+	}
+}
+
 func RegisterIPSet(ip string) {
-	cc.Set(ip, time.Now().Unix(), cache.DefaultExpiration)
+	limitSet(ip)
 }
 
 func RegisterIPGet(ip string) int {
@@ -43,17 +97,17 @@ func PostLimitGet(lang, username string) int {
 
 func PostLimitSet(lang, username string) {
 	postRate := lang + ":p:" + username
-	cc.Set(postRate, time.Now().Unix(), cache.DefaultExpiration)
+	limitSet(postRate)
 }
 
 func PostLimitDel(lang, username string) {
 	postRate := lang + ":p:" + username
-	cc.Delete(postRate)
+	limitDel(postRate)
 }
 
 func ComLimitSet(lang, username string) {
 	rateComKey := lang + ":c:" + username
-	cc.Set(rateComKey, time.Now().Unix(), cache.DefaultExpiration)
+	limitSet(rateComKey)
 }
 
 func ComLimitGet(lang, username string) int {
@@ -61,15 +115,6 @@ func ComLimitGet(lang, username string) int {
 	return ratelimit(rateComKey, RateComment)
 }
 
-func UserBanGet(username string) bool {
-	_, bannedAuthor := cc.Get("ban:uid:" + username)
-	return bannedAuthor
-}
-
-func UserBanSet(author string) {
-	cc.Set("ban:uid:"+author, time.Now().Unix(), cache.DefaultExpiration)
-}
-
 func ratelimit(key string, dur time.Duration) (wait int) {
 	if key == "" {
 		return 0
@@ -115,7 +160,7 @@ func ArticleViewGet(lang, ip string, aid uint32) (view int) {
 	return view
 }
 
-func ComUpSet(lang, username, cid string) error {
+func ComUpSet(lang, username string) error {
 	unicCnt := fmt.Sprintf("%s:cuidcnt:%s", lang, username)
 
 	if val, found := cc.Get(unicCnt); !found {
@@ -132,15 +177,8 @@ func ComUpSet(lang, username, cid string) error {
 		// add vote
 		cc.IncrementInt(unicCnt, 1)
 	}
-	// one comment - one vote
-	uniq := fmt.Sprintf("%s:ciduid:%s:%s", lang, cid, username)
-	if _, found := cc.Get(uniq); !found {
-		// uniq
-		cc.Set(uniq, 1, 24*30*time.Hour) // 30 days
-	} else {
-		return errors.New("Oh: only one vote for each comment allowed(")
-	}
-
+	counterStore(unicCnt)
+	// one comment - one vote, see CommentVote
 	return nil
 }
 
@@ -161,6 +199,7 @@ func VoteSet(lang, username string) error {
 		// add vote
 		cc.IncrementInt(unicCnt, 1)
 	}
+	counterStore(unicCnt)
 	return nil
 }
 
@@ -175,3 +214,7 @@ func Type2TeleGet(aid uint32) int {
 	}
 	return 0
 }
+
+func Type2TeleDel(aid uint32) {
+	cc.Delete(fmt.Sprintf("type2tele:%d", aid))
+}
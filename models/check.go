@@ -0,0 +1,579 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ImgGrace - new images may be not used yet, they are not orphaned before it
+var ImgGrace = 24 * time.Hour
+
+// Problem - inconsistency found in language database
+type Problem struct {
+	Kind string // dangling, unlisted, count or orphan
+	What string
+	fix  func()
+}
+
+func (p Problem) String() string {
+	return p.Kind + ": " + p.What
+}
+
+// checker collect problems and fix them on repair
+type checker struct {
+	lang     string
+	repair   bool
+	problems []Problem
+}
+
+func (c *checker) add(kind, what string, fix func()) {
+	c.problems = append(c.problems, Problem{Kind: kind, What: what, fix: fix})
+	if c.repair {
+		fix()
+	}
+}
+
+// Check scan database of language and return dangling references and orphaned images
+// with repair problems are fixed
+func Check(lang string, repair bool) (problems []Problem, err error) {
+	c := &checker{lang: lang, repair: repair}
+
+	fUser := fmt.Sprintf(dbUser, lang)
+	userKeys, err := db.Keys(fUser, nil, 0, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]bool)
+	for _, u := range userKeys {
+		users[string(u)] = true
+	}
+
+	// articles: aid - author
+	live := make(map[uint32]string)
+	trashed := make(map[uint32]string)
+	texts := []string{}
+	fAids := fmt.Sprintf(dbAids, lang)
+	keys, _ := db.Keys(fAids, nil, 0, 0, true)
+	for _, k := range keys {
+		k := k
+		author, err := db.Get(fAids, k)
+		if err == nil {
+			if has, _ := db.Has(fmt.Sprintf(dbAUser, lang, author), k); has {
+				live[BintoUint32(k)] = string(author)
+				continue
+			}
+		}
+		c.add("dangling", fmt.Sprintf("%s: article %d of @%s not found", fAids, BintoUint32(k), author), func() {
+			db.Delete(fAids, k)
+		})
+	}
+	var articles []Article
+	for u := range users {
+		fAUser := fmt.Sprintf(dbAUser, lang, u)
+		keys, _ := db.Keys(fAUser, nil, 0, 0, true)
+		for _, k := range keys {
+			var a Article
+			if err := recordGet(fAUser, k, schemaArticle, "", &a); err != nil {
+				continue
+			}
+			a.Lang = lang
+			articles = append(articles, a)
+			texts = append(texts, a.Body, a.OgImage)
+			if _, ok := live[a.ID]; ok {
+				continue
+			}
+			live[a.ID] = u
+			author, id32 := []byte(u), Uint32toBin(a.ID)
+			c.add("unlisted", fmt.Sprintf("%s: article %d of @%s", fAids, a.ID, u), func() {
+				db.Set(fAids, id32, author)
+			})
+		}
+		fTrash := fmt.Sprintf(dbTrash, lang, u)
+		keys, _ = db.Keys(fTrash, nil, 0, 0, true)
+		for _, k := range keys {
+			var t Trashed
+			if err := recordGet(fTrash, k, schemaArticle, "Article", &t); err == nil {
+				trashed[t.Article.ID] = u
+				texts = append(texts, t.Article.Body, t.Article.OgImage)
+			}
+		}
+		for _, d := range Drafts(lang, u) {
+			texts = append(texts, d.Article.Body, d.Article.OgImage)
+		}
+	}
+	exists := func(aid uint32) bool {
+		_, ok := live[aid]
+		_, ok2 := trashed[aid]
+		return ok || ok2
+	}
+
+	c.checkTags(articles)
+
+	// views are kept for trashed articles, search only for live
+	fView := fmt.Sprintf(dbView, lang)
+	keys, _ = db.Keys(fView, nil, 0, 0, true)
+	for _, k := range keys {
+		k := k
+		if len(k) == 4 && exists(BintoUint32(k)) {
+			continue
+		}
+		c.add("dangling", fmt.Sprintf("%s: article %v not found", fView, k), func() {
+			db.Delete(fView, k)
+		})
+	}
+	fDoc := fmt.Sprintf(dbSearchDoc, lang)
+	keys, _ = db.Keys(fDoc, nil, 0, 0, true)
+	for _, k := range keys {
+		if len(k) != 4 {
+			continue
+		}
+		aid := BintoUint32(k)
+		if _, ok := live[aid]; ok {
+			continue
+		}
+		c.add("dangling", fmt.Sprintf("%s: article %d not found", fDoc, aid), func() {
+			SearchRemove(lang, aid)
+		})
+	}
+
+	// ranking of live articles
+	fRank := fmt.Sprintf(dbRank, lang)
+	ranked := make(map[uint32]bool)
+	keys, _ = db.Keys(fRank, nil, 0, 0, true)
+	for _, k := range keys {
+		if len(k) == 4 {
+			if _, ok := live[BintoUint32(k)]; ok {
+				ranked[BintoUint32(k)] = true
+				continue
+			}
+		}
+		k := k
+		c.add("dangling", fmt.Sprintf("%s: article %v not found", fRank, k), func() {
+			db.Delete(fRank, k)
+		})
+	}
+	for i := range articles {
+		a := &articles[i]
+		if ranked[a.ID] {
+			continue
+		}
+		c.add("unlisted", fmt.Sprintf("%s: article %d of @%s", fRank, a.ID, a.Author), func() {
+			rankSync(lang, a.ID)
+		})
+	}
+
+	// comments and revisions: aid+id
+	cids := make(map[uint32]bool)
+	fCom := fmt.Sprintf(dbComment, lang)
+	fRev := fmt.Sprintf(dbRevision, lang)
+	for _, f := range []string{fCom, fRev} {
+		f := f
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			if len(k) != 8 {
+				continue
+			}
+			if exists(BintoUint32(k[:4])) {
+				if f == fCom {
+					cids[BintoUint32(k[4:])] = true
+					var com Article
+					if recordGet(f, k, schemaArticle, "", &com) == nil {
+						texts = append(texts, com.Body)
+					}
+				} else {
+					var r Revision
+					if recordGet(f, k, schemaArticle, "Article", &r) == nil {
+						texts = append(texts, r.Article.Body, r.Article.OgImage)
+					}
+				}
+				continue
+			}
+			c.add("dangling", fmt.Sprintf("%s: %d of article %d not found", f, BintoUint32(k[4:]), BintoUint32(k[:4])), func() {
+				db.Delete(f, k)
+			})
+		}
+	}
+
+	// threads: reply to missed comment, tombstone without replies
+	coms := make(map[uint32]map[uint32]Article)
+	replies := make(map[uint32]int)
+	keys, _ = db.Keys(fCom, nil, 0, 0, true)
+	for _, k := range keys {
+		var com Article
+		if len(k) != 8 || !exists(BintoUint32(k[:4])) || recordGet(fCom, k, schemaArticle, "", &com) != nil {
+			continue
+		}
+		aid := BintoUint32(k[:4])
+		if coms[aid] == nil {
+			coms[aid] = make(map[uint32]Article)
+		}
+		coms[aid][com.ID] = com
+		replies[com.Parent]++
+	}
+	for aid, byID := range coms {
+		aid := aid
+		for cid, com := range byID {
+			cid := cid
+			if _, ok := byID[com.Parent]; com.Parent != 0 && !ok {
+				c.add("dangling", fmt.Sprintf("%s: parent %d of comment %d not found", fCom, com.Parent, cid), func() {
+					CommentModify(lang, aid, cid, func(com *Article) error {
+						com.Parent = 0
+						return nil
+					})
+					commentsTreeRebuild(lang, aid)
+				})
+			}
+			if com.Deleted && replies[cid] == 0 {
+				c.add("dangling", fmt.Sprintf("%s: deleted comment %d without replies", fCom, cid), func() {
+					db.Delete(fCom, commentKey(aid, cid))
+					db.Delete(fmt.Sprintf(dbCommentTree, lang), treeKey(aid, com.Parent, cid))
+					rankComments(lang, aid, -1)
+				})
+			}
+		}
+	}
+	// index of threads aid+parent+cid
+	fTree := fmt.Sprintf(dbCommentTree, lang)
+	tree := make(map[uint32]map[uint32]uint32)
+	keys, _ = db.Keys(fTree, nil, 0, 0, true)
+	for _, k := range keys {
+		k := k
+		if len(k) == 12 {
+			aid, cid := BintoUint32(k[:4]), BintoUint32(k[8:])
+			if com, ok := coms[aid][cid]; ok && com.Parent == BintoUint32(k[4:8]) {
+				if tree[aid] == nil {
+					tree[aid] = make(map[uint32]uint32)
+				}
+				tree[aid][cid] = com.Parent
+				continue
+			}
+		}
+		c.add("dangling", fmt.Sprintf("%s: comment %v not found", fTree, k), func() {
+			db.Delete(fTree, k)
+		})
+	}
+	for aid, byID := range coms {
+		aid := aid
+		for cid := range byID {
+			if _, ok := tree[aid][cid]; !ok {
+				c.add("unlisted", fmt.Sprintf("%s: comment %d of article %d", fTree, cid, aid), func() {
+					commentsTreeRebuild(lang, aid)
+				})
+				break
+			}
+		}
+	}
+
+	// votes: username:id
+	for cat, ok := range map[string]func(uint32) bool{"a": exists, "c": func(id uint32) bool { return cids[id] }} {
+		f := fmt.Sprintf(dbVote, lang, cat)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			if len(k) < 5 || ok(BintoUint32(k[len(k)-4:])) && users[string(k[:len(k)-5])] {
+				continue
+			}
+			c.add("dangling", fmt.Sprintf("%s: vote of @%s on %d", f, k[:len(k)-5], BintoUint32(k[len(k)-4:])), func() {
+				db.Delete(f, k)
+			})
+		}
+	}
+
+	// follows master:slave, favorites and muted articles aid:username
+	for _, cat := range []string{"fol", "blk", "mut"} {
+		c.checkEdges(cat, func(master []byte) bool { return users[string(master)] }, users)
+	}
+	for _, cat := range []string{"fav", "amut"} {
+		c.checkEdges(cat, func(master []byte) bool { return len(master) == 4 && exists(BintoUint32(master)) }, users)
+	}
+	c.checkEdges("tag", func(master []byte) bool {
+		tags, err := ParseTags(string(master))
+		return err == nil && len(tags) == 1 && tags[0] == string(master)
+	}, users)
+
+	// mentions
+	for u := range users {
+		u := u
+		f := fmt.Sprintf(dbMention, lang, u)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			var m Mention
+			if err := db.GetGob(f, k, &m); err == nil && exists(m.Aid) {
+				continue
+			}
+			c.add("dangling", fmt.Sprintf("%s: mention of article %d", f, m.Aid), func() {
+				refRemove(lang, "m", u, k)
+			})
+		}
+		if user, err := UserGet(lang, u); err == nil {
+			texts = append(texts, user.Image)
+		}
+	}
+
+	// notifications and unread index of them
+	for u := range users {
+		u := u
+		f := fmt.Sprintf(dbNotify, lang, u)
+		fUnread := fmt.Sprintf(dbNotifyUnread, lang, u)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			var n Notification
+			if err := recordGet(f, k, schemaNotify, "", &n); err == nil && users[n.By] && (n.Aid == 0 || exists(n.Aid)) {
+				continue
+			}
+			c.add("dangling", fmt.Sprintf("%s: notification by @%s of article %d", f, n.By, n.Aid), func() {
+				refRemove(lang, "n", u, k)
+			})
+		}
+		keys, _ = db.Keys(fUnread, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			if has, _ := db.Has(f, k); has {
+				continue
+			}
+			c.add("dangling", fmt.Sprintf("%s: notification %d not found", fUnread, BintoUint32(k)), func() {
+				db.Delete(fUnread, k)
+			})
+		}
+	}
+
+	c.checkRefs(users)
+	c.checkImages(texts)
+	return c.problems, nil
+}
+
+// checkRefs compare indexes by article with mentions, notifications and votes
+func (c *checker) checkRefs(users map[string]bool) {
+	lang := c.lang
+	for _, cat := range []string{"m", "n"} {
+		fRefs := fmt.Sprintf(dbRefs, lang, cat)
+		indexed := make(map[string]bool)
+		keys, _ := db.Keys(fRefs, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			if len(k) > 4 {
+				if i := bytes.IndexByte(k[4:], ':'); i > 0 && refAid(lang, cat, string(k[4:4+i]), k[5+i:]) == BintoUint32(k[:4]) {
+					indexed[string(k)] = true
+					continue
+				}
+			}
+			c.add("dangling", fmt.Sprintf("%s: record of article %d not found", fRefs, BintoUint32(k)), func() {
+				db.Delete(fRefs, k)
+			})
+		}
+		for u := range users {
+			u := u
+			f := refFile(lang, cat, u)
+			keys, _ := db.Keys(f, nil, 0, 0, true)
+			for _, k := range keys {
+				k := k
+				aid := refAid(lang, cat, u, k)
+				if aid == 0 || indexed[string(refKey(aid, u, k))] {
+					continue
+				}
+				c.add("unlisted", fmt.Sprintf("%s: record of article %d not indexed", f, aid), func() {
+					refAdd(lang, cat, aid, u, k)
+				})
+			}
+		}
+	}
+
+	// votes index id+username
+	for _, cat := range []string{"a", "c"} {
+		f := fmt.Sprintf(dbVote, lang, cat)
+		fIDs := fmt.Sprintf(dbVoteIDs, lang, cat)
+		keys, _ := db.Keys(fIDs, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			if len(k) > 4 {
+				if has, _ := db.Has(f, voteKey(string(k[4:]), BintoUint32(k[:4]))); has {
+					continue
+				}
+			}
+			c.add("dangling", fmt.Sprintf("%s: vote of @%s on %d not found", fIDs, k[4:], BintoUint32(k)), func() {
+				db.Delete(fIDs, k)
+			})
+		}
+		keys, _ = db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			if len(k) < 5 {
+				continue
+			}
+			key := voteIDKey(string(k[:len(k)-5]), BintoUint32(k[len(k)-4:]))
+			if has, _ := db.Has(fIDs, key); has {
+				continue
+			}
+			c.add("unlisted", fmt.Sprintf("%s: vote of @%s on %d not indexed", f, k[:len(k)-5], BintoUint32(k[len(k)-4:])), func() {
+				db.Set(fIDs, key, nil)
+			})
+		}
+		// recent votes time+id+username, time is kept in index
+		fLog := fmt.Sprintf(dbVoteLog, lang, cat)
+		keys, _ = db.Keys(fLog, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			if len(k) > 12 && voteAt(lang, cat, string(k[12:]), BintoUint32(k[8:12])) == int64(binary.BigEndian.Uint64(k)) {
+				continue
+			}
+			c.add("dangling", fmt.Sprintf("%s: recent vote %v not found", fLog, k), func() {
+				db.Delete(fLog, k)
+			})
+		}
+	}
+}
+
+// checkTags compare tag indexes and counts with tags of articles
+func (c *checker) checkTags(articles []Article) {
+	lang := c.lang
+	want := make(map[string]map[uint32]string)
+	for _, a := range articles {
+		for _, t := range a.TagList() {
+			if want[t] == nil {
+				want[t] = make(map[uint32]string)
+			}
+			want[t][a.ID] = a.Author
+		}
+	}
+	fTags := fmt.Sprintf(dbTags, lang)
+	counts := make(map[string]int)
+	keys, _ := db.Keys(fTags, nil, 0, 0, true)
+	for _, k := range keys {
+		if b, err := db.Get(fTags, k); err == nil && len(b) == 4 {
+			counts[string(k)] = int(BintoUint32(b))
+		}
+	}
+	tags := make(map[string]bool)
+	for t := range want {
+		tags[t] = true
+	}
+	for t := range counts {
+		tags[t] = true
+	}
+	for t := range tags {
+		t := t
+		fATag := fmt.Sprintf(dbATag, lang, t)
+		indexed := make(map[uint32]bool)
+		keys, _ := db.Keys(fATag, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			aid := BintoUint32(k)
+			indexed[aid] = true
+			if _, ok := want[t][aid]; ok {
+				continue
+			}
+			c.add("dangling", fmt.Sprintf("%s: article %d", fATag, aid), func() {
+				db.Delete(fATag, k)
+			})
+		}
+		for aid, author := range want[t] {
+			if indexed[aid] {
+				continue
+			}
+			id32, author := Uint32toBin(aid), []byte(author)
+			c.add("unlisted", fmt.Sprintf("%s: article %d", fATag, aid), func() {
+				db.Set(fATag, id32, author)
+			})
+		}
+		if cnt := len(want[t]); cnt != counts[t] {
+			c.add("count", fmt.Sprintf("%s: tag %s has %d articles, stored %d", fTags, t, cnt, counts[t]), func() {
+				if cnt == 0 {
+					db.Delete(fTags, []byte(t))
+					return
+				}
+				db.Set(fTags, []byte(t), Uint32toBin(uint32(cnt)))
+			})
+		}
+	}
+}
+
+// checkEdges check both directions of follow edges
+func (c *checker) checkEdges(cat string, masterOk func([]byte) bool, users map[string]bool) {
+	ms := fmt.Sprintf(dbMasterSlave, c.lang, cat)
+	sm := fmt.Sprintf(dbSlaveMaster, c.lang, cat)
+	// master may contain ':', slave is username without ':'
+	split := func(k []byte, masterFirst bool) (master, slave []byte, ok bool) {
+		if masterFirst {
+			for i := len(k) - 1; i >= 0; i-- {
+				if k[i] == ':' {
+					return k[:i], k[i+1:], true
+				}
+			}
+			return nil, nil, false
+		}
+		for i := range k {
+			if k[i] == ':' {
+				return k[i+1:], k[:i], true
+			}
+		}
+		return nil, nil, false
+	}
+	for _, dir := range []struct {
+		f, other    string
+		masterFirst bool
+	}{{ms, sm, true}, {sm, ms, false}} {
+		dir := dir
+		keys, _ := db.Keys(dir.f, nil, 0, 0, true)
+		for _, k := range keys {
+			k := k
+			master, slave, ok := split(k, dir.masterFirst)
+			if ok && masterOk(master) && users[string(slave)] {
+				masterslave, slavemaster := GetMasterSlave(string(master), string(slave))
+				back := slavemaster
+				if !dir.masterFirst {
+					back = masterslave
+				}
+				if has, _ := db.Has(dir.other, back); has {
+					continue
+				}
+			}
+			c.add("dangling", fmt.Sprintf("%s: %q", dir.f, k), func() {
+				db.Delete(dir.f, k)
+			})
+		}
+	}
+}
+
+var imgName = regexp.MustCompile(`^(\d+)_?\.png$`)
+
+// checkImages find stored images not used in any text
+func (c *checker) checkImages(texts []string) {
+	dir := DataPath(filepath.Join("img", c.lang))
+	refs := make(map[string]bool)
+	ref := regexp.MustCompile(`i/` + regexp.QuoteMeta(c.lang) + `/([A-Za-z0-9]+)/(\d+)_?\.png`)
+	for _, t := range texts {
+		for _, m := range ref.FindAllStringSubmatch(t, -1) {
+			refs[m[1]+"/"+m[2]] = true
+		}
+	}
+	userDirs, _ := ioutil.ReadDir(dir)
+	before := time.Now().Add(-ImgGrace)
+	for _, ud := range userDirs {
+		if !ud.IsDir() {
+			continue
+		}
+		files, _ := ioutil.ReadDir(filepath.Join(dir, ud.Name()))
+		for _, f := range files {
+			m := imgName.FindStringSubmatch(f.Name())
+			if m == nil || f.ModTime().After(before) {
+				continue
+			}
+			if id, _ := strconv.Atoi(m[1]); refs[ud.Name()+"/"+strconv.Itoa(id)] {
+				continue
+			}
+			path := filepath.Join(dir, ud.Name(), f.Name())
+			c.add("orphan", path, func() {
+				os.Remove(path)
+			})
+		}
+	}
+}
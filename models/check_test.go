@@ -0,0 +1,130 @@
+package models_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestPurgeCascade(t *testing.T) {
+	defer useMemStorage()()
+
+	for _, u := range []string{"alice", "bob"} {
+		if err := models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "article body"})
+	cid, _ := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "@alice comment"}, "alice", aid)
+	models.MentionNew("@alice comment", "tst", "comment", "bob", "/@alice/1#comment", "/@alice/1#comment", aid, cid)
+	models.CommentVote("tst", "alice", aid, cid, true)
+	models.ArticleVote("tst", "bob", "alice", aid, models.VoteUp)
+	models.Following("tst", "fav", string(models.Uint32toBin(aid)), "bob")
+	models.ViewSet("tst", aid, 5)
+	time.Sleep(10 * time.Millisecond) // ViewSet is async
+
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Fatalf("want consistent db, got %v", problems)
+	}
+	models.ArticleDelete("tst", "alice", aid)
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Fatalf("want trash consistent, got %v", problems)
+	}
+	if err := models.ArticlePurge("tst", "alice", aid); err != nil {
+		t.Fatal(err)
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want purge to cascade, got %v", problems)
+	}
+	if len(models.Mentions("tst", "alice")) != 0 || models.CommentsCount("tst", aid) != 0 ||
+		models.IsFollowing("tst", "fav", string(models.Uint32toBin(aid)), "bob") {
+		t.Error("want mentions, comments and favorites removed")
+	}
+}
+
+func TestCheckRepair(t *testing.T) {
+	defer useMemStorage()()
+	dir, err := ioutil.TempDir("", "tgram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	models.SetRoot(dir)
+	defer models.SetRoot(".")
+
+	models.UserNew(&models.User{Lang: "tst", Username: "alice", Password: "password"})
+	models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "with ![](http://h/i/tst/alice/1.png)", Tag: "go"})
+
+	db := models.GetStorage()
+	// ghost article in list and tag, stale count, favorite of missing article
+	db.Set("db/tst/aids", models.Uint32toBin(99), []byte("alice"))
+	db.Set("db/tst/t/go", models.Uint32toBin(99), []byte("alice"))
+	db.Set("db/tst/tags", []byte("go"), models.Uint32toBin(2))
+	models.Following("tst", "fav", string(models.Uint32toBin(99)), "alice")
+	// used and orphaned images
+	old := time.Now().Add(-2 * models.ImgGrace)
+	for _, name := range []string{"1.png", "1_.png", "2.png", "2_.png"} {
+		path := filepath.Join(dir, "img/tst/alice", name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("png"), 0644)
+		os.Chtimes(path, old, old)
+	}
+
+	problems, err := models.Check("tst", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]int)
+	for _, p := range problems {
+		kinds[p.Kind]++
+	}
+	// aids, tag index, 2 fav edges; count; 2 images
+	if kinds["dangling"] != 4 || kinds["count"] != 1 || kinds["orphan"] != 2 {
+		t.Errorf("want 4 dangling, 1 count, 2 orphan, got %v", problems)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "img/tst/alice/2.png")); err != nil {
+		t.Error("want check without repair to keep files")
+	}
+
+	models.Check("tst", true)
+	if problems, _ = models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want repaired db, got %v", problems)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "img/tst/alice/1_.png")); err != nil {
+		t.Error("want used image kept")
+	}
+	if tags := models.Tags("tst"); len(tags) != 1 || tags[0].Count != 1 {
+		t.Errorf("want tag count repaired, got %v", tags)
+	}
+}
+
+func TestCheckImagesMixedCase(t *testing.T) {
+	defer useMemStorage()()
+	dir, err := ioutil.TempDir("", "tgram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	models.SetRoot(dir)
+	defer models.SetRoot(".")
+
+	models.UserNew(&models.User{Lang: "tst", Username: "Alice", Password: "password"})
+	models.ArticleNew(&models.Article{Lang: "tst", Author: "Alice", Body: "with ![](http://h/i/tst/Alice/1.png)"})
+	old := time.Now().Add(-2 * models.ImgGrace)
+	for _, name := range []string{"1.png", "1_.png"} {
+		path := filepath.Join(dir, "img/tst/Alice", name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte("png"), 0644)
+		os.Chtimes(path, old, old)
+	}
+	if problems, _ := models.Check("tst", true); len(problems) != 0 {
+		t.Errorf("want images of mixed case user used, got %v", problems)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "img/tst/Alice/1_.png")); err != nil {
+		t.Error("want used image kept")
+	}
+}
@@ -0,0 +1,338 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// aid/cid
+	dbComment = "db/%s/com"
+	// aid+parent+cid - threads of comments, top level comments have parent 0
+	dbCommentTree = "db/%s/comt"
+
+	// CommentsPage - top level comments per page on article page, shown with replies
+	CommentsPage = 50
+	// CommentDepth - max nesting of replies, deeper replies are shown on this level
+	CommentDepth = 4
+)
+
+// commentKey return aid+cid key, comments of article are sorted by cid
+func commentKey(aid, cid uint32) []byte {
+	return append(Uint32toBin(aid), Uint32toBin(cid)...)
+}
+
+// commentsPrefix return prefix for all comments of article
+func commentsPrefix(aid uint32) []byte {
+	return append(Uint32toBin(aid), '*')
+}
+
+// treeKey return aid+parent+cid key, replies of parent are sorted by cid
+func treeKey(aid, parent, cid uint32) []byte {
+	return append(commentKey(aid, parent), Uint32toBin(cid)...)
+}
+
+// replies return ids of replies to parent, 0 - top level comments
+func replies(lang string, aid, parent uint32) (cids []uint32) {
+	keys, _ := db.Keys(fmt.Sprintf(dbCommentTree, lang), append(commentKey(aid, parent), '*'), 0, 0, true)
+	for _, k := range keys {
+		if len(k) == 12 {
+			cids = append(cids, BintoUint32(k[8:]))
+		}
+	}
+	return cids
+}
+
+// CommentNew create comment, reply if Parent is set
+func CommentNew(a *Article, user string, mainaid uint32) (id uint32, err error) {
+	if id, err = commentNew(a, user, mainaid); err != nil {
+		return 0, err
+	}
+	// ranking has own lock, so it is changed after parent is unlocked
+	rankComments(a.Lang, mainaid, 1)
+	return id, nil
+}
+
+func commentNew(a *Article, user string, mainaid uint32) (id uint32, err error) {
+	a.CreatedAt = time.Now()
+	a.Deleted = false
+	has, err := db.Has(fmt.Sprintf(dbAUser, a.Lang, user), Uint32toBin(mainaid))
+	if !has || err != nil {
+		return 0, errors.New("Article not found")
+	}
+	if IsBlocked(a.Lang, user, a.Author) {
+		return 0, errors.New("You are blocked by author")
+	}
+	if a.Parent != 0 {
+		// parent is not deleted before reply is stored
+		unlock := lockKey(fmt.Sprintf(dbComment, a.Lang), commentKey(mainaid, a.Parent))
+		defer unlock()
+		parent, err := CommentGet(a.Lang, mainaid, a.Parent)
+		if err != nil {
+			return 0, errors.New("Parent comment not found")
+		}
+		if parent.Deleted {
+			return 0, errors.New("Comment deleted")
+		}
+	}
+	fAid := fmt.Sprintf(dbAid, a.Lang)
+
+	cid, err := db.Counter(fAid, []byte("cid"))
+	if err != nil {
+		return 0, err
+	}
+	a.ID = uint32(cid)
+	// store
+	if err = recordSet(fmt.Sprintf(dbComment, a.Lang), commentKey(mainaid, a.ID), schemaArticle, a); err != nil {
+		return 0, err
+	}
+	return a.ID, db.Set(fmt.Sprintf(dbCommentTree, a.Lang), treeKey(mainaid, a.Parent, a.ID), nil)
+}
+
+// CommentGet return comment of article
+func CommentGet(lang string, aid, cid uint32) (c *Article, err error) {
+	err = recordGet(fmt.Sprintf(dbComment, lang), commentKey(aid, cid), schemaArticle, "", &c)
+	if err != nil {
+		return nil, errors.New("Comment not found")
+	}
+	return c, nil
+}
+
+// CommentUpd update comment of article
+func CommentUpd(c *Article, aid uint32) (err error) {
+	return recordSet(fmt.Sprintf(dbComment, c.Lang), commentKey(aid, c.ID), schemaArticle, c)
+}
+
+// CommentModify atomically load comment, apply fn and store it
+// fn may return error to cancel update
+func CommentModify(lang string, aid, cid uint32, fn func(c *Article) error) (c *Article, err error) {
+	unlock := lockKey(fmt.Sprintf(dbComment, lang), commentKey(aid, cid))
+	defer unlock()
+
+	c, err = CommentGet(lang, aid, cid)
+	if err != nil {
+		return nil, err
+	}
+	if err = fn(c); err != nil {
+		return nil, err
+	}
+	c.Lang = lang
+	return c, CommentUpd(c, aid)
+}
+
+// CommentDelete delete comment and return id of previous comment in thread or 0
+// comment with replies is replaced by tombstone and its id is returned,
+// tombstone of parent is deleted with its last reply
+func CommentDelete(lang string, aid, cid uint32) (prev uint32, err error) {
+	prev, parent, removed, err := commentDelete(lang, aid, cid, false)
+	for removed {
+		// ranking has own lock, so it is changed after comment is unlocked
+		rankComments(lang, aid, -1)
+		if parent == 0 {
+			break
+		}
+		var p uint32
+		if p, parent, removed, err = commentDelete(lang, aid, parent, true); removed {
+			prev = p
+		}
+	}
+	return prev, err
+}
+
+// commentDelete delete comment under its lock, return parent if comment is removed
+// tombstone mode removes only tombstone without replies
+func commentDelete(lang string, aid, cid uint32, tombstone bool) (prev, parent uint32, removed bool, err error) {
+	f := fmt.Sprintf(dbComment, lang)
+	key := commentKey(aid, cid)
+	unlock := lockKey(f, key)
+	defer unlock()
+
+	c, err := CommentGet(lang, aid, cid)
+	if err != nil {
+		if tombstone {
+			return 0, 0, false, nil
+		}
+		return 0, 0, false, err
+	}
+	prev = commentPrev(lang, aid, c.Parent, cid)
+	hasReplies := len(replies(lang, aid, cid)) > 0
+	if tombstone && (!c.Deleted || hasReplies) {
+		return prev, 0, false, nil
+	}
+	if hasReplies {
+		c.Author, c.Image, c.Body, c.HTML, c.Plus, c.Deleted = "", "", "", "", 0, true
+		c.Lang = lang
+		votesDelete(lang, "c", map[uint32]bool{cid: true})
+		return cid, 0, false, CommentUpd(c, aid)
+	}
+	if _, err = db.Delete(f, key); err != nil {
+		return prev, 0, false, err
+	}
+	db.Delete(fmt.Sprintf(dbCommentTree, lang), treeKey(aid, c.Parent, cid))
+	return prev, c.Parent, true, nil
+}
+
+// commentPrev return comment shown before comment in thread: last reply of
+// previous sibling or parent, 0 for first comment
+func commentPrev(lang string, aid, parent, cid uint32) uint32 {
+	prev := parent
+	for _, id := range replies(lang, aid, parent) {
+		if id >= cid {
+			break
+		}
+		prev = id
+	}
+	if prev == parent {
+		return prev
+	}
+	for {
+		ids := replies(lang, aid, prev)
+		if len(ids) == 0 {
+			return prev
+		}
+		prev = ids[len(ids)-1]
+	}
+}
+
+// commentsTree append comment and its replies in order of thread,
+// Depth is limited by CommentDepth
+func commentsTree(lang string, aid, cid uint32, depth int, thread []Article) []Article {
+	c, err := CommentGet(lang, aid, cid)
+	if err != nil {
+		return thread
+	}
+	c.Depth = depth
+	if c.Depth > CommentDepth {
+		c.Depth = CommentDepth
+	}
+	thread = append(thread, *c)
+	for _, id := range replies(lang, aid, cid) {
+		thread = commentsTree(lang, aid, id, depth+1, thread)
+	}
+	return thread
+}
+
+// Comments return comments of page of top level comments with their replies
+// in order of threads, count of all comments and count of top level comments
+func Comments(lang string, aid uint32, page int) (comments []Article, cnt, threads int) {
+	cnt = CommentsCount(lang, aid)
+	if tree, _ := db.Keys(fmt.Sprintf(dbCommentTree, lang), commentsPrefix(aid), 0, 0, true); len(tree) != cnt {
+		// comments stored before threads were indexed
+		commentsTreeRebuild(lang, aid)
+	}
+	top := replies(lang, aid, 0)
+	threads = len(top)
+	from := page * CommentsPage
+	if page < 0 || from >= threads {
+		return comments, cnt, threads
+	}
+	to := from + CommentsPage
+	if to > threads {
+		to = threads
+	}
+	for _, cid := range top[from:to] {
+		comments = commentsTree(lang, aid, cid, 0, comments)
+	}
+	return comments, cnt, threads
+}
+
+// CommentsCount return count of comments of article
+func CommentsCount(lang string, aid uint32) int {
+	keys, _ := db.Keys(fmt.Sprintf(dbComment, lang), commentsPrefix(aid), 0, 0, true)
+	return len(keys)
+}
+
+// CommentPage return page on which thread of comment is shown
+func CommentPage(lang string, aid, cid uint32) int {
+	// parent is always older, so there are no cycles
+	for cid != 0 {
+		c, err := CommentGet(lang, aid, cid)
+		if err != nil || c.Parent == 0 || c.Parent >= cid {
+			break
+		}
+		cid = c.Parent
+	}
+	for i, id := range replies(lang, aid, 0) {
+		if id == cid {
+			return i / CommentsPage
+		}
+	}
+	return 0
+}
+
+// commentsTreeRebuild index threads of comments of article, return count of indexed
+// reply to missed comment is indexed on top level
+func commentsTreeRebuild(lang string, aid uint32) (indexed int) {
+	f := fmt.Sprintf(dbComment, lang)
+	fTree := fmt.Sprintf(dbCommentTree, lang)
+	old, _ := db.Keys(fTree, commentsPrefix(aid), 0, 0, true)
+	for _, k := range old {
+		db.Delete(fTree, k)
+	}
+	keys, _ := db.Keys(f, commentsPrefix(aid), 0, 0, true)
+	ids := make(map[uint32]bool, len(keys))
+	all := make([]Article, 0, len(keys))
+	for _, k := range keys {
+		var c Article
+		if len(k) == 8 && recordGet(f, k, schemaArticle, "", &c) == nil {
+			all = append(all, c)
+			ids[BintoUint32(k[4:])] = true
+		}
+	}
+	for _, c := range all {
+		parent := c.Parent
+		if !ids[parent] || parent >= c.ID {
+			parent = 0
+		}
+		db.Set(fTree, treeKey(aid, parent, c.ID), nil)
+		indexed++
+	}
+	return indexed
+}
+
+// CommentsRebuild index threads of comments of all articles, return count of indexed
+func CommentsRebuild(lang string) (indexed int, err error) {
+	keys, err := db.Keys(fmt.Sprintf(dbAids, lang), nil, 0, 0, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		indexed += commentsTreeRebuild(lang, BintoUint32(k))
+	}
+	return indexed, nil
+}
+
+// CommentsMigrate move comments stored inside article gob to dbComment
+// return count of migrated articles and comments
+func CommentsMigrate(lang string) (articles, comments int, err error) {
+	fAids := fmt.Sprintf(dbAids, lang)
+	fCom := fmt.Sprintf(dbComment, lang)
+	keys, err := db.Keys(fAids, nil, 0, 0, true)
+	if err != nil {
+		return articles, comments, err
+	}
+	for _, key := range keys {
+		author, err := db.Get(fAids, key)
+		if err != nil {
+			continue
+		}
+		a, err := ArticleGet(lang, string(author), BintoUint32(key))
+		if err != nil || len(a.Comments) == 0 {
+			continue
+		}
+		for _, c := range a.Comments {
+			if err = recordSet(fCom, commentKey(a.ID, c.ID), schemaArticle, c); err != nil {
+				return articles, comments, err
+			}
+			db.Set(fmt.Sprintf(dbCommentTree, lang), treeKey(a.ID, c.Parent, c.ID), nil)
+		}
+		comments += len(a.Comments)
+		a.Comments = nil
+		if err = ArticleUpd(a, a.TagList()); err != nil {
+			return articles, comments, err
+		}
+		articles++
+	}
+	return articles, comments, nil
+}
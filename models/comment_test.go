@@ -0,0 +1,206 @@
+package models_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestComments(t *testing.T) {
+	defer useMemStorage()()
+
+	a := &models.Article{Lang: "tst", Author: "alice", Body: "article body"}
+	aid, err := models.ArticleNew(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = models.CommentNew(&models.Article{Lang: "tst", Author: "bob"}, "nobody", aid); err == nil {
+		t.Error("comment on missing article")
+	}
+	var cids []uint32
+	for i := 0; i < models.CommentsPage+2; i++ {
+		cid, err := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "comment"}, "alice", aid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, cid)
+	}
+	comments, cnt, _ := models.Comments("tst", aid, 1)
+	if cnt != models.CommentsPage+2 || len(comments) != 2 {
+		t.Fatalf("want 2 of %d comments on second page, got %d of %d", models.CommentsPage+2, len(comments), cnt)
+	}
+	if comments[1].ID != cids[len(cids)-1] {
+		t.Errorf("want last comment %d, got %d", cids[len(cids)-1], comments[1].ID)
+	}
+	if page := models.CommentPage("tst", aid, cids[len(cids)-1]); page != 1 {
+		t.Errorf("want page 1, got %d", page)
+	}
+	prev, err := models.CommentDelete("tst", aid, cids[1])
+	if err != nil || prev != cids[0] {
+		t.Errorf("want prev %d, got %d (%v)", cids[0], prev, err)
+	}
+	if models.CommentsCount("tst", aid) != models.CommentsPage+1 {
+		t.Error("comment not deleted")
+	}
+	// article is not touched by comments
+	stored, _ := models.ArticleGet("tst", "alice", aid)
+	if len(stored.Comments) != 0 {
+		t.Errorf("want no comments in article, got %d", len(stored.Comments))
+	}
+}
+
+func TestCommentThreads(t *testing.T) {
+	defer useMemStorage()()
+	for _, u := range []string{"alice", "bob", "carol"} {
+		models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"})
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "article body"})
+	reply := func(by string, parent uint32) uint32 {
+		cid, err := models.CommentNew(&models.Article{Lang: "tst", Author: by, Body: "comment", Parent: parent}, "alice", aid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cid
+	}
+	// chain of replies to first comment deeper than limit, then second comment
+	first := reply("bob", 0)
+	second := reply("carol", 0)
+	parent := first
+	var chain []uint32
+	for i := 0; i < models.CommentDepth+1; i++ {
+		parent = reply(map[bool]string{true: "carol", false: "bob"}[i%2 == 0], parent)
+		chain = append(chain, parent)
+	}
+	if _, err := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "comment", Parent: 999}, "alice", aid); err == nil {
+		t.Error("want reply to missed comment refused")
+	}
+
+	comments, _, _ := models.Comments("tst", aid, 0)
+	if len(comments) != len(chain)+2 || comments[1].ID != chain[0] || comments[len(comments)-1].ID != second {
+		t.Fatalf("want replies after parent, got %+v", comments)
+	}
+	if comments[1].Depth != 1 || comments[len(chain)].Depth != models.CommentDepth {
+		t.Errorf("want depth limited, got %d %d", comments[1].Depth, comments[len(chain)].Depth)
+	}
+	n := models.Notification{By: "carol", Aid: aid, Cid: chain[0], Path: "/@alice/1"}
+	if got := models.CommentNotify("tst", "alice", n, nil); len(got) != 2 || got[0].Username != "bob" {
+		t.Errorf("want author of parent notified first, got %+v", got)
+	}
+	if list, _, _, _ := models.Notifications("tst", "bob", 0); len(list) != 1 || list[0].Kind != models.NotifyReply {
+		t.Errorf("want reply notification, got %+v", list)
+	}
+
+	// parent with replies is kept as tombstone
+	if prev, err := models.CommentDelete("tst", aid, first); err != nil || prev != first {
+		t.Fatalf("want tombstone, got %d %v", prev, err)
+	}
+	if c, _ := models.CommentGet("tst", aid, first); !c.Deleted || c.Author != "" || c.Body != "" {
+		t.Errorf("want deleted comment without author and body, got %+v", c)
+	}
+	if _, err := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "comment", Parent: first}, "alice", aid); err == nil {
+		t.Error("want reply to deleted comment refused")
+	}
+	if _, err := models.CommentVote("tst", "alice", aid, first, true); err == nil {
+		t.Error("want vote on deleted comment refused")
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+	// tombstones are deleted with last reply
+	for i := len(chain) - 1; i > 0; i-- {
+		models.CommentDelete("tst", aid, chain[i])
+	}
+	if _, err := models.CommentDelete("tst", aid, chain[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, cnt, _ := models.Comments("tst", aid, 0); cnt != 1 || models.CommentsCount("tst", aid) != 1 {
+		t.Errorf("want only second comment, got %d", cnt)
+	}
+}
+
+func TestCommentsMigrate(t *testing.T) {
+	defer useMemStorage()()
+
+	a := &models.Article{Lang: "tst", Author: "alice", Body: "article body"}
+	aid, err := models.ArticleNew(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Comments = []models.Article{{ID: 7, Author: "bob", Body: "old"}, {ID: 9, Author: "eve", Body: "older"}}
+	if err = models.ArticleUpd(a, a.TagList()); err != nil {
+		t.Fatal(err)
+	}
+	articles, comments, err := models.CommentsMigrate("tst")
+	if err != nil || articles != 1 || comments != 2 {
+		t.Fatalf("want 1 article and 2 comments migrated, got %d, %d (%v)", articles, comments, err)
+	}
+	stored, _ := models.ArticleGet("tst", "alice", aid)
+	if len(stored.Comments) != 0 {
+		t.Error("comments left in article")
+	}
+	c, err := models.CommentGet("tst", aid, 9)
+	if err != nil || c.Body != "older" {
+		t.Errorf("want migrated comment, got %+v (%v)", c, err)
+	}
+}
+
+func TestCommentReplyDelete(t *testing.T) {
+	defer useMemStorage()()
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "article body"})
+	for i := 0; i < 100; i++ {
+		parent, _ := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "comment"}, "alice", aid)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			models.CommentDelete("tst", aid, parent)
+		}()
+		_, err := models.CommentNew(&models.Article{Lang: "tst", Author: "carol", Body: "reply", Parent: parent}, "alice", aid)
+		wg.Wait()
+		if _, e := models.CommentGet("tst", aid, parent); err == nil && e != nil {
+			t.Fatalf("want parent of reply kept as tombstone, got %v", e)
+		}
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+}
+
+func TestCommentsPageThreads(t *testing.T) {
+	defer useMemStorage()()
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "article body"})
+	comment := func(parent uint32) uint32 {
+		cid, err := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "comment", Parent: parent}, "alice", aid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cid
+	}
+	var top []uint32
+	for i := 0; i < models.CommentsPage+1; i++ {
+		top = append(top, comment(0))
+	}
+	first := comment(top[1])
+	last := comment(top[models.CommentsPage])
+
+	comments, cnt, threads := models.Comments("tst", aid, 1)
+	if cnt != models.CommentsPage+3 || threads != models.CommentsPage+1 {
+		t.Fatalf("want all comments and top level counted, got %d %d", cnt, threads)
+	}
+	if len(comments) != 2 || comments[1].ID != last || comments[1].Depth != 1 {
+		t.Errorf("want last thread with reply on second page, got %+v", comments)
+	}
+	if page := models.CommentPage("tst", aid, last); page != 1 {
+		t.Errorf("want reply on page of its thread, got %d", page)
+	}
+	if prev, err := models.CommentDelete("tst", aid, top[2]); err != nil || prev != first {
+		t.Errorf("want last reply of previous thread, got %d %v", prev, err)
+	}
+	if prev, err := models.CommentDelete("tst", aid, last); err != nil || prev != top[models.CommentsPage] {
+		t.Errorf("want parent of first reply, got %d %v", prev, err)
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+}
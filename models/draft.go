@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// did - Draft of author
+	dbDraft = "db/%s/d/%s"
+	// publishAt:lang:did - author, drafts of all languages waiting for publish
+	dbSchedule = "db/schedule"
+)
+
+// Draft - unpublished article, visible only to author
+type Draft struct {
+	ID        uint32
+	Article   Article
+	SavedAt   time.Time
+	PublishAt time.Time // zero - not scheduled
+}
+
+// Scheduled - draft waiting for publish
+type Scheduled struct {
+	Lang      string
+	Author    string
+	DID       uint32
+	PublishAt time.Time
+}
+
+func scheduleKey(lang string, did uint32, at time.Time) []byte {
+	key := make([]byte, 8, 8+len(lang)+5)
+	binary.BigEndian.PutUint64(key, uint64(at.Unix()))
+	key = append(key, lang+":"...)
+	return append(key, Uint32toBin(did)...)
+}
+
+func parseScheduleKey(key []byte) (s Scheduled, ok bool) {
+	if len(key) < 8+1+4 || key[len(key)-5] != ':' {
+		return s, false
+	}
+	s.PublishAt = time.Unix(int64(binary.BigEndian.Uint64(key[:8])), 0)
+	s.Lang = string(key[8 : len(key)-5])
+	s.DID = BintoUint32(key[len(key)-4:])
+	return s, true
+}
+
+// DraftSave create draft if d.ID is 0 or update draft of author
+// draft with PublishAt is scheduled for publish
+func DraftSave(lang, author string, d *Draft) (id uint32, err error) {
+	f := fmt.Sprintf(dbDraft, lang, author)
+	isNew := d.ID == 0
+	if isNew {
+		did, err := db.Counter(fmt.Sprintf(dbAid, lang), []byte("did"))
+		if err != nil {
+			return 0, err
+		}
+		d.ID = uint32(did)
+	}
+	unlock := lockKey(f, Uint32toBin(d.ID))
+	defer unlock()
+
+	if !isNew {
+		old, err := DraftGet(lang, author, d.ID)
+		if err != nil {
+			return 0, err
+		}
+		if !old.PublishAt.IsZero() {
+			db.Delete(dbSchedule, scheduleKey(lang, d.ID, old.PublishAt))
+		}
+	}
+	d.SavedAt = time.Now()
+	d.Article.Lang = lang
+	d.Article.Author = author
+	if err = recordSet(f, Uint32toBin(d.ID), schemaArticle, d); err != nil {
+		return 0, err
+	}
+	if !d.PublishAt.IsZero() {
+		if err = db.Set(dbSchedule, scheduleKey(lang, d.ID, d.PublishAt), []byte(author)); err != nil {
+			return 0, err
+		}
+	}
+	return d.ID, nil
+}
+
+// DraftGet return draft of author
+func DraftGet(lang, author string, did uint32) (d *Draft, err error) {
+	if err = recordGet(fmt.Sprintf(dbDraft, lang, author), Uint32toBin(did), schemaArticle, "Article", &d); err != nil {
+		return nil, errors.New("Draft not found")
+	}
+	return d, nil
+}
+
+// Drafts return drafts of author, newest first
+func Drafts(lang, author string) (drafts []Draft) {
+	f := fmt.Sprintf(dbDraft, lang, author)
+	keys, _ := db.Keys(f, nil, 0, 0, false)
+	for _, k := range keys {
+		var d Draft
+		if err := recordGet(f, k, schemaArticle, "Article", &d); err != nil {
+			continue
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts
+}
+
+// draftDelete remove draft and its schedule, called under draft lock
+func draftDelete(lang, author string, did uint32) (d *Draft, err error) {
+	d, err = DraftGet(lang, author, did)
+	if err != nil {
+		return nil, err
+	}
+	if !d.PublishAt.IsZero() {
+		db.Delete(dbSchedule, scheduleKey(lang, did, d.PublishAt))
+	}
+	_, err = db.Delete(fmt.Sprintf(dbDraft, lang, author), Uint32toBin(did))
+	return d, err
+}
+
+// DraftDelete remove draft of author
+func DraftDelete(lang, author string, did uint32) (err error) {
+	unlock := lockKey(fmt.Sprintf(dbDraft, lang, author), Uint32toBin(did))
+	defer unlock()
+	_, err = draftDelete(lang, author, did)
+	return err
+}
+
+// DraftPublish create article from draft and remove draft
+func DraftPublish(lang, author string, did uint32) (a *Article, err error) {
+	unlock := lockKey(fmt.Sprintf(dbDraft, lang, author), Uint32toBin(did))
+	defer unlock()
+
+	d, err := DraftGet(lang, author, did)
+	if err != nil {
+		return nil, err
+	}
+	a = &d.Article
+	a.Lang = lang
+	a.Author = author
+	a.ID = 0
+	if _, err = ArticleNew(a); err != nil {
+		return nil, err
+	}
+	if _, err = draftDelete(lang, author, did); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// DraftsDue return scheduled drafts of all languages with publish time before now
+func DraftsDue(now time.Time) (due []Scheduled) {
+	keys, _ := db.Keys(dbSchedule, nil, 0, 0, true)
+	for _, k := range keys {
+		s, ok := parseScheduleKey(k)
+		if !ok {
+			db.Delete(dbSchedule, k)
+			continue
+		}
+		if s.PublishAt.After(now) {
+			break
+		}
+		author, err := db.Get(dbSchedule, k)
+		if err != nil {
+			continue
+		}
+		s.Author = string(author)
+		if has, _ := db.Has(fmt.Sprintf(dbDraft, s.Lang, s.Author), Uint32toBin(s.DID)); !has {
+			db.Delete(dbSchedule, k)
+			continue
+		}
+		due = append(due, s)
+	}
+	return due
+}
@@ -0,0 +1,58 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestDrafts(t *testing.T) {
+	defer useMemStorage()()
+
+	d := &models.Draft{Article: models.Article{Title: "draft", Body: "draft body", Tag: "go"}}
+	did, err := models.DraftSave("tst", "alice", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = models.DraftSave("tst", "bob", &models.Draft{ID: did}); err == nil {
+		t.Error("want error on update of draft of other author")
+	}
+	if drafts := models.Drafts("tst", "alice"); len(drafts) != 1 || drafts[0].Article.Title != "draft" {
+		t.Errorf("want draft of alice, got %v", drafts)
+	}
+	if articles, _, _, _, _, _ := models.AllArticles("tst", "", ""); len(articles) != 0 {
+		t.Errorf("draft must not be visible, got %v", articles)
+	}
+
+	// schedule, reschedule
+	now := time.Now()
+	d.PublishAt = now.Add(time.Hour)
+	models.DraftSave("tst", "alice", d)
+	d.PublishAt = now.Add(2 * time.Hour)
+	models.DraftSave("tst", "alice", d)
+	if due := models.DraftsDue(now.Add(90 * time.Minute)); len(due) != 0 {
+		t.Errorf("want rescheduled draft not due, got %v", due)
+	}
+	due := models.DraftsDue(now.Add(3 * time.Hour))
+	if len(due) != 1 || due[0].Lang != "tst" || due[0].Author != "alice" || due[0].DID != did {
+		t.Fatalf("want scheduled draft due, got %v", due)
+	}
+
+	a, err := models.DraftPublish("tst", "alice", did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID == 0 || a.Author != "alice" || a.Tag != "go" {
+		t.Errorf("want published article, got %v", a)
+	}
+	if _, err = models.DraftGet("tst", "alice", did); err == nil {
+		t.Error("want draft removed on publish")
+	}
+	if due := models.DraftsDue(now.Add(3 * time.Hour)); len(due) != 0 {
+		t.Errorf("want schedule removed on publish, got %v", due)
+	}
+	if _, err = models.DraftPublish("tst", "alice", did); err == nil {
+		t.Error("want error on second publish")
+	}
+}
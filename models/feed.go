@@ -0,0 +1,157 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FeedPage - articles per page of feed
+const FeedPage = 20
+
+// feedSource - followed author ("fol") or tag ("tag")
+type feedSource struct {
+	cat    string
+	master string
+}
+
+// feedItem - article in feed with sources it came from
+type feedItem struct {
+	aid     uint32
+	author  string
+	sources []feedSource
+}
+
+// Feed return page of articles of authors and tags followed by user, newest first,
+// without muted authors
+// before is cursor, id of last article of previous page or 0 for first page
+// next is cursor of next page or 0 if it was last, shown articles are marked as seen
+func Feed(lang, username string, before uint32) (articles []Article, next uint32, err error) {
+	byAid := make(map[uint32]*feedItem)
+	muted := Muted(lang, username)
+	add := func(src feedSource, ids []uint32, author func(aid uint32) string) {
+		for _, aid := range ids {
+			it, ok := byAid[aid]
+			if !ok {
+				it = &feedItem{aid: aid, author: author(aid)}
+				if muted[it.author] {
+					continue
+				}
+				byAid[aid] = it
+			}
+			it.sources = append(it.sources, src)
+		}
+	}
+	for _, cat := range []string{"fol", "tag"} {
+		smf := fmt.Sprintf(dbSlaveMaster, lang, cat)
+		keys, _ := db.Keys(smf, append([]byte(username+":"), '*'), 0, 0, true)
+		for _, k := range keys {
+			src := feedSource{cat: cat, master: string(k[len(username)+1:])}
+			if cat == "fol" {
+				f := fmt.Sprintf(dbAUser, lang, src.master)
+				add(src, keysBefore(f, before, FeedPage+1), func(uint32) string { return src.master })
+				continue
+			}
+			// tag index stores author of article
+			f := fmt.Sprintf(dbATag, lang, src.master)
+			add(src, keysBefore(f, before, FeedPage+1), func(aid uint32) string {
+				b, _ := db.Get(f, Uint32toBin(aid))
+				return string(b)
+			})
+		}
+	}
+	items := make([]*feedItem, 0, len(byAid))
+	for _, it := range byAid {
+		items = append(items, it)
+	}
+	// ids are global, so newest first
+	sort.Slice(items, func(i, j int) bool { return items[i].aid > items[j].aid })
+	if len(items) > FeedPage {
+		items = items[:FeedPage]
+		next = items[FeedPage-1].aid
+	}
+
+	seen := make(map[feedSource]uint32)
+	for _, it := range items {
+		a, err := ArticleGet(lang, it.author, it.aid)
+		if err != nil {
+			continue
+		}
+		a.CommentCnt = CommentsCount(lang, a.ID)
+		articles = append(articles, *a)
+		for _, src := range it.sources {
+			if it.aid > seen[src] {
+				seen[src] = it.aid
+			}
+		}
+	}
+	for src, aid := range seen {
+		feedSeen(lang, src.cat, username, src.master, aid)
+	}
+	return articles, next, nil
+}
+
+// feedSeen move last seen article of followed author or tag forward
+func feedSeen(lang, cat, username, master string, aid uint32) {
+	smf := fmt.Sprintf(dbSlaveMaster, lang, cat)
+	_, slavemaster := GetMasterSlave(master, username)
+	unlock := lockKey(smf, slavemaster)
+	defer unlock()
+	b, err := db.Get(smf, slavemaster)
+	if err != nil {
+		// unfollowed
+		return
+	}
+	if len(b) != 4 || BintoUint32(b) < aid {
+		db.Set(smf, slavemaster, Uint32toBin(aid))
+	}
+}
+
+// keysBefore return up to limit ids of file less than before (0 - from last), descending
+func keysBefore(f string, before uint32, limit int) (ids []uint32) {
+	var from []byte
+	if before > 0 {
+		from = Uint32toBin(before)
+		if has, _ := db.Has(f, from); !has {
+			from = nil
+		}
+	}
+	// from is excluded, so it's a fast path if cursor is in file
+	if before == 0 || from != nil {
+		keys, _ := db.Keys(f, from, uint32(limit), 0, false)
+		for _, k := range keys {
+			ids = append(ids, BintoUint32(k))
+		}
+		return ids
+	}
+	for offset := uint32(0); ; offset += uint32(limit) {
+		keys, _ := db.Keys(f, nil, uint32(limit), offset, false)
+		for _, k := range keys {
+			if id := BintoUint32(k); id < before {
+				ids = append(ids, id)
+				if len(ids) == limit {
+					return ids
+				}
+			}
+		}
+		if len(keys) < limit {
+			return ids
+		}
+	}
+}
+
+// countAfter return count of ids of file greater than after
+func countAfter(f string, after uint32) (cnt int) {
+	const chunk = 100
+	for offset := uint32(0); ; offset += chunk {
+		keys, _ := db.Keys(f, nil, chunk, offset, false)
+		for _, k := range keys {
+			if BintoUint32(k) <= after {
+				return cnt
+			}
+			cnt++
+		}
+		if len(keys) < chunk {
+			return cnt
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestFeed(t *testing.T) {
+	defer useMemStorage()()
+	var want []uint32
+	for i := 0; i < 15; i++ {
+		for _, u := range []string{"alice", "bob", "carol"} {
+			aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: u, Body: "body"})
+			if u != "carol" {
+				want = append([]uint32{aid}, want...)
+			}
+		}
+	}
+	models.Following("tst", "fol", "alice", "dave")
+	models.Following("tst", "fol", "bob", "dave")
+	// alice trashed one article, it's not in feed
+	models.ArticleTrash("tst", "alice", want[1], "alice")
+	want = append(want[:1], want[2:]...)
+
+	var got []uint32
+	before := uint32(0)
+	for page := 0; page < 5; page++ {
+		articles, next, err := models.Feed("tst", "dave", before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range articles {
+			got = append(got, a.ID)
+		}
+		if next == 0 {
+			break
+		}
+		before = next
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d articles, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+	for _, u := range models.IFollow("tst", "fol", "dave") {
+		if u.Unseen != 0 {
+			t.Errorf("want seen articles of @%s, got %d unseen", u.Username, u.Unseen)
+		}
+	}
+}
+
+func TestFeedTags(t *testing.T) {
+	defer useMemStorage()()
+	for _, u := range []string{"alice", "bob", "carol", "dave"} {
+		models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"})
+	}
+	old, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "old", Tag: "go"})
+	if err := models.FollowTag("tst", "go", "dave"); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.FollowTag("tst", "go rust", "dave"); err == nil {
+		t.Error("want invalid tag")
+	}
+	models.Following("tst", "fol", "bob", "dave")
+	both, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "bob", Body: "bob", Tag: "go"})
+	tagged, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "carol", Body: "carol", Tag: "go"})
+	models.ArticleNew(&models.Article{Lang: "tst", Author: "carol", Body: "other", Tag: "rust"})
+
+	tags := models.IFollowTags("tst", "dave")
+	if len(tags) != 1 || tags[0].Name != "go" || tags[0].LastSeen != old || tags[0].Unseen != 2 {
+		t.Fatalf("want new articles of tag unseen, got %+v", tags)
+	}
+	articles, _, _ := models.Feed("tst", "dave", 0)
+	if len(articles) != 3 || articles[0].ID != tagged || articles[1].ID != both || articles[2].ID != old {
+		t.Fatalf("want articles of tag and author once, got %v", articles)
+	}
+	if tags = models.IFollowTags("tst", "dave"); tags[0].Unseen != 0 {
+		t.Errorf("want tag seen, got %+v", tags)
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+	models.UserDelete("tst", "dave")
+	if models.IsFollowing("tst", "tag", "go", "dave") {
+		t.Error("want tag follow deleted with user")
+	}
+}
@@ -16,7 +16,6 @@ import (
 
 	"github.com/MaxHalford/halfgone"
 	"github.com/nfnt/resize"
-	sp "github.com/recoilme/slowpoke"
 	"github.com/recoilme/tgram/utils"
 )
 
@@ -94,11 +93,11 @@ func Store(href, lang, username string, b []byte) (file, orig string, origSize i
 		//if err := png.Encode(thumbb, thumb); err == nil {
 		atk := halfgone.AtkinsonDitherer{}.Apply(halfgone.ImageToGray(thumb))
 		//store
-		if imgid, err := sp.Counter(fmt.Sprintf(dbImgID, lang, username), []byte("id")); err == nil {
+		if imgid, err := db.Counter(fmt.Sprintf(dbImgID, lang, username), []byte("id")); err == nil {
 			path := fmt.Sprintf(fileImg, lang, username, imgid, ".png")
-			if _, err := utils.CheckAndCreate(path); err == nil {
+			if _, err := utils.CheckAndCreate(DataPath(path)); err == nil {
 				// save Atkinson
-				f, err := os.Create(path)
+				f, err := os.Create(DataPath(path))
 				defer f.Close()
 				if err == nil {
 					if err := png.Encode(f, atk); err == nil {
@@ -107,7 +106,7 @@ func Store(href, lang, username string, b []byte) (file, orig string, origSize i
 				}
 				// save orig
 				pathOrig := fmt.Sprintf(fileImg, lang, username, imgid, "_.png")
-				fo, err := os.Create(pathOrig)
+				fo, err := os.Create(DataPath(pathOrig))
 				defer fo.Close()
 				if err == nil {
 					if err := png.Encode(fo, thumb); err == nil {
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"sync"
+)
+
+// keyLock - lock of record, removed when nobody holds or waits for it
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// record locks by keyspace and key, so locks of different records never collide
+var locks = struct {
+	sync.Mutex
+	m map[string]*keyLock
+}{m: make(map[string]*keyLock)}
+
+// lockKey lock record for read-modify-write, call returned func to unlock
+// lock is not reentrant: record must not be locked twice by one goroutine
+func lockKey(file string, key []byte) func() {
+	k := file + "\x00" + string(key)
+	locks.Lock()
+	l, ok := locks.m[k]
+	if !ok {
+		l = &keyLock{}
+		locks.m[k] = l
+	}
+	l.refs++
+	locks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		locks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(locks.m, k)
+		}
+		locks.Unlock()
+	}
+}
@@ -29,8 +29,8 @@ func SendMail(SMTPHost, SMTPPort, SMTPUser, SMTPPassword, Domain, address, title
 		smtpServer,
 	)
 
-	from := mail.Address{Domain, SMTPUser}
-	to := mail.Address{address, address}
+	from := mail.Address{Name: Domain, Address: SMTPUser}
+	to := mail.Address{Name: address, Address: address}
 
 	header := make(map[string]string)
 	header["From"] = from.String()
@@ -63,6 +63,6 @@ func SendMail(SMTPHost, SMTPPort, SMTPUser, SMTPPassword, Domain, address, title
 func encodeRFC2047(String string) string {
 	// use mail's rfc2047 to encode any string
 	// strange title in ""?
-	addr := mail.Address{String, ""}
+	addr := mail.Address{Name: String, Address: ""}
 	return strings.Trim(addr.String(), " <@>")
 }
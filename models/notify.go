@@ -0,0 +1,236 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// nid - Notification of user
+	dbNotify = "db/%s/n/%s"
+	// nid of unread notifications of user
+	dbNotifyUnread = "db/%s/nu/%s"
+
+	// NotifyPage - notifications per page of inbox
+	NotifyPage = 30
+)
+
+// kinds of notifications
+const (
+	NotifyFollow   = "follow"
+	NotifyComment  = "comment"
+	NotifyThread   = "thread" // comment on article user commented
+	NotifyReply    = "reply"
+	NotifyMention  = "mention"
+	NotifyVote     = "vote"
+	NotifyFavorite = "favorite"
+)
+
+// Notification - event for user by other user
+type Notification struct {
+	ID      uint32    `json:"id"`
+	Kind    string    `json:"kind"`
+	By      string    `json:"by"`
+	Aid     uint32    `json:"aid,omitempty"`
+	Cid     uint32    `json:"cid,omitempty"`
+	Path    string    `json:"path"`
+	Text    string    `json:"text,omitempty"`
+	Read    bool      `json:"read"`
+	Created time.Time `json:"created"`
+}
+
+// notifySkip return true if user is not notified about actions of by:
+// own actions and actions of users blocked or muted by user
+func notifySkip(lang, username, by string) bool {
+	return username == "" || username == by || IsBlocked(lang, username, by) ||
+		IsFollowing(lang, "mut", by, username) || !UserExists(lang, username)
+}
+
+// NotifyNew store unread notification for user, nothing is stored
+// for own actions and actions of users blocked or muted by user
+func NotifyNew(lang, username string, n Notification) (err error) {
+	if notifySkip(lang, username, n.By) {
+		return nil
+	}
+	nid, err := db.Counter(fmt.Sprintf(dbAid, lang), []byte("nid"))
+	if err != nil {
+		return err
+	}
+	n.ID = uint32(nid)
+	n.Read = false
+	if n.Created.IsZero() {
+		n.Created = time.Now()
+	}
+	id32 := Uint32toBin(n.ID)
+	if err = recordSet(fmt.Sprintf(dbNotify, lang, username), id32, schemaNotify, &n); err != nil {
+		return err
+	}
+	if n.Aid != 0 {
+		refAdd(lang, "n", n.Aid, username, id32)
+	}
+	return db.Set(fmt.Sprintf(dbNotifyUnread, lang, username), id32, nil)
+}
+
+// Notifications return page of notifications of user, newest first, and count of all and unread
+func Notifications(lang, username string, page int) (list []Notification, cnt, unread int, err error) {
+	f := fmt.Sprintf(dbNotify, lang, username)
+	all, _ := db.Count(f)
+	cnt = int(all)
+	unread = NotifyUnread(lang, username)
+	if page < 0 || page*NotifyPage >= cnt {
+		return list, cnt, unread, nil
+	}
+	keys, err := db.Keys(f, nil, NotifyPage, uint32(page*NotifyPage), false)
+	if err != nil {
+		return nil, cnt, unread, err
+	}
+	for _, k := range keys {
+		var n Notification
+		if err = recordGet(f, k, schemaNotify, "", &n); err != nil {
+			return nil, cnt, unread, err
+		}
+		list = append(list, n)
+	}
+	return list, cnt, unread, nil
+}
+
+// NotifyUnread return count of unread notifications of user
+func NotifyUnread(lang, username string) int {
+	cnt, _ := db.Count(fmt.Sprintf(dbNotifyUnread, lang, username))
+	return int(cnt)
+}
+
+// NotifyRead mark unread notifications of user about article read, all if aid is 0
+// return count of marked
+func NotifyRead(lang, username string, aid uint32) (marked int, err error) {
+	f := fmt.Sprintf(dbNotify, lang, username)
+	fUnread := fmt.Sprintf(dbNotifyUnread, lang, username)
+	keys, _ := db.Keys(fUnread, nil, 0, 0, true)
+	for _, k := range keys {
+		var n Notification
+		if err = recordGet(f, k, schemaNotify, "", &n); err == nil {
+			if aid != 0 && n.Aid != aid {
+				continue
+			}
+			n.Read = true
+			if err = recordSet(f, k, schemaNotify, &n); err != nil {
+				return marked, err
+			}
+		}
+		// unread without notification is dropped too
+		db.Delete(fUnread, k)
+		marked++
+	}
+	return marked, nil
+}
+
+// CommentNotify notify author of replied comment, author of article and previous
+// commenters following comments about new comment n of article, users who muted
+// article and skip (mentioned) are not notified, return notified users for email
+func CommentNotify(lang, author string, n Notification, skip []string) (notified []User) {
+	done := map[string]bool{n.By: true}
+	for _, u := range skip {
+		done[u] = true
+	}
+	notify := func(username, kind string, subscribed bool) {
+		if done[username] {
+			return
+		}
+		done[username] = true
+		if notifySkip(lang, username, n.By) {
+			return
+		}
+		u, err := UserGet(lang, username)
+		if err != nil || (subscribed && !u.FollowComments) || IsArticleMuted(lang, n.Aid, username) {
+			return
+		}
+		n.Kind = kind
+		if err = NotifyNew(lang, username, n); err != nil {
+			log.Println(err)
+			return
+		}
+		notified = append(notified, *u)
+	}
+	if c, err := CommentGet(lang, n.Aid, n.Cid); err == nil && c.Parent != 0 {
+		if parent, err := CommentGet(lang, n.Aid, c.Parent); err == nil {
+			notify(parent.Author, NotifyReply, false)
+		}
+	}
+	notify(author, NotifyComment, false)
+	fCom := fmt.Sprintf(dbComment, lang)
+	keys, _ := db.Keys(fCom, commentsPrefix(n.Aid), 0, 0, true)
+	for _, k := range keys {
+		var c Article
+		if recordGet(fCom, k, schemaArticle, "", &c) == nil {
+			notify(c.Author, NotifyThread, true)
+		}
+	}
+	return notified
+}
+
+// ArticleMute stop notifications about new comments of article for user
+func ArticleMute(lang string, aid uint32, username string) (err error) {
+	if ArticleAuthor(lang, aid) == "" {
+		return errors.New("Article not found")
+	}
+	return Following(lang, "amut", string(Uint32toBin(aid)), username)
+}
+
+// ArticleUnmute resume notifications about new comments of article for user
+func ArticleUnmute(lang string, aid uint32, username string) (err error) {
+	return Unfollowing(lang, "amut", string(Uint32toBin(aid)), username)
+}
+
+// IsArticleMuted return true if user muted comments of article
+func IsArticleMuted(lang string, aid uint32, username string) bool {
+	if username == "" {
+		return false
+	}
+	return IsFollowing(lang, "amut", string(Uint32toBin(aid)), username)
+}
+
+// SendComments email notified users about new comment n, unless they disabled it
+func SendComments(lang, SMTPHost, SMTPPort, SMTPUser, SMTPPassword, Domain string, n Notification, users []User) {
+	if !IsSmtpSet(SMTPHost, SMTPPort, SMTPUser, SMTPPassword) {
+		return
+	}
+	for _, u := range users {
+		if u.Email == "" || u.NoCommentMail {
+			continue
+		}
+		title := "New comment from @" + n.By
+		body := "@" + n.By + " commented:\n\n" + n.Text + "\n\nLink:\n" + "https://" + lang + "." + Domain + n.Path +
+			"\n\nMute this article on its page or disable emails in settings."
+		SendMail(SMTPHost, SMTPPort, SMTPUser, SMTPPassword, Domain, u.Email, title, body)
+	}
+}
+
+// notifyClear remove all notifications of user
+func notifyClear(lang, username string) {
+	keys, _ := db.Keys(fmt.Sprintf(dbNotify, lang, username), nil, 0, 0, true)
+	for _, k := range keys {
+		refRemove(lang, "n", username, k)
+	}
+	f := fmt.Sprintf(dbNotifyUnread, lang, username)
+	keys, _ = db.Keys(f, nil, 0, 0, true)
+	for _, k := range keys {
+		db.Delete(f, k)
+	}
+}
+
+// notifyDelete remove notifications of all users matched by fn
+func notifyDelete(lang string, fn func(n *Notification) bool) {
+	users, _ := db.Keys(fmt.Sprintf(dbUser, lang), nil, 0, 0, true)
+	for _, u := range users {
+		f := fmt.Sprintf(dbNotify, lang, u)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			var n Notification
+			if recordGet(f, k, schemaNotify, "", &n) == nil && fn(&n) {
+				refRemove(lang, "n", string(u), k)
+			}
+		}
+	}
+}
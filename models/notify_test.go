@@ -0,0 +1,106 @@
+package models_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestNotifications(t *testing.T) {
+	defer useMemStorage()()
+	for _, u := range []string{"alice", "bob", "carol"} {
+		models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"})
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "alice article"})
+	models.NotifyNew("tst", "alice", models.Notification{Kind: models.NotifyFollow, By: "bob", Path: "/@bob"})
+	models.NotifyNew("tst", "alice", models.Notification{Kind: models.NotifyVote, By: "alice", Aid: aid})
+	models.Block("tst", "mut", "alice", "carol")
+	models.NotifyNew("tst", "alice", models.Notification{Kind: models.NotifyFollow, By: "carol", Path: "/@carol"})
+	cid, _ := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "[@alice](/@alice) thanks @carol"}, "alice", aid)
+	models.MentionNew("[@alice](/@alice) thanks @carol", "tst", "thanks", "bob", "/@alice/1", "/@alice/1#comment1", aid, cid)
+	for i := 0; i < models.NotifyPage; i++ {
+		models.NotifyNew("tst", "alice", models.Notification{Kind: models.NotifyFavorite, By: "bob", Aid: aid})
+	}
+
+	list, cnt, unread, err := models.Notifications("tst", "alice", 0)
+	if err != nil || cnt != models.NotifyPage+2 || unread != cnt || len(list) != models.NotifyPage {
+		t.Fatalf("want own and muted skipped, got %d %d %d %v", cnt, unread, len(list), err)
+	}
+	if list[0].Kind != models.NotifyFavorite || list[0].Read {
+		t.Errorf("want newest first, got %+v", list[0])
+	}
+	if list, _, _, _ = models.Notifications("tst", "alice", 1); len(list) != 2 || list[0].Kind != models.NotifyReply || list[1].Kind != models.NotifyFollow {
+		t.Errorf("want reply and follow on second page, got %+v", list)
+	}
+	if list, _, _, _ = models.Notifications("tst", "carol", 0); len(list) != 1 || list[0].Kind != models.NotifyMention {
+		t.Errorf("want mention, got %+v", list)
+	}
+
+	// opened article, then the rest
+	if n, _ := models.NotifyRead("tst", "alice", aid); n != models.NotifyPage+1 || models.NotifyUnread("tst", "alice") != 1 {
+		t.Errorf("want notifications of article read, got %d", n)
+	}
+	var archive bytes.Buffer
+	models.Export("tst", &archive)
+	models.NotifyRead("tst", "alice", 0)
+	if list, _, unread, _ = models.Notifications("tst", "alice", 1); unread != 0 || !list[1].Read {
+		t.Errorf("want all read, got %d %+v", unread, list)
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+
+	models.SetStorage(models.NewMemStorage())
+	models.Import("tst", bytes.NewReader(archive.Bytes()))
+	if _, cnt, unread, _ = models.Notifications("tst", "alice", 0); cnt != models.NotifyPage+2 || unread != 1 {
+		t.Errorf("want notifications imported, got %d %d", cnt, unread)
+	}
+
+	models.UserDelete("tst", "bob")
+	if _, cnt, _, _ = models.Notifications("tst", "alice", 0); cnt != 0 {
+		t.Errorf("want notifications by deleted user removed, got %d", cnt)
+	}
+}
+
+func TestCommentNotify(t *testing.T) {
+	defer useMemStorage()()
+	for _, u := range []string{"alice", "bob", "carol", "dave"} {
+		models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password", FollowComments: u == "bob"})
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "alice article"})
+	comment := func(by string, skip ...string) (names []string) {
+		cid, _ := models.CommentNew(&models.Article{Lang: "tst", Author: by, Body: "comment"}, "alice", aid)
+		n := models.Notification{By: by, Aid: aid, Cid: cid, Path: "/@alice/1", Text: "comment"}
+		for _, u := range models.CommentNotify("tst", "alice", n, skip) {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+
+	if got := comment("bob"); len(got) != 1 || got[0] != "alice" {
+		t.Errorf("want author notified, got %v", got)
+	}
+	if got := comment("carol"); len(got) != 2 || got[1] != "bob" {
+		t.Errorf("want subscribed commenter notified, got %v", got)
+	}
+	if list, _, _, _ := models.Notifications("tst", "bob", 0); len(list) != 1 || list[0].Kind != models.NotifyThread {
+		t.Errorf("want thread notification, got %+v", list)
+	}
+	models.ArticleMute("tst", aid, "alice")
+	if got := comment("dave", "bob"); len(got) != 0 {
+		t.Errorf("want muted and mentioned skipped, got %v", got)
+	}
+	models.ArticleUnmute("tst", aid, "alice")
+	models.Block("tst", "mut", "bob", "carol")
+	if got := comment("carol"); len(got) != 1 || got[0] != "alice" {
+		t.Errorf("want subscriber who muted commenter skipped, got %v", got)
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+	models.ArticleUnmute("tst", aid, "alice")
+	if got := comment("dave"); len(got) != 2 {
+		t.Errorf("want unmuted author notified, got %v", got)
+	}
+}
@@ -0,0 +1,403 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// aid - RankEntry of live article: created, votes, comments, views and author
+	dbRank = "db/%s/rank"
+
+	// RankPage - articles per page of top and bottom
+	RankPage = 20
+)
+
+// RankStore - sorted lists are cached and recomputed by RankRefresh
+var RankStore = 10 * time.Minute
+
+// RankRising - votes of this period are recent, see RankEntry.RecentPlus
+var RankRising = 24 * time.Hour
+
+// RankWindows - periods of top by votes, "" - all articles by score with gravity
+var RankWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"all":   0,
+}
+
+// RankGravity - how fast articles sink with age by language, "" is default
+// https://medium.com/hacking-and-gonzo/how-hacker-news-ranking-algorithm-works-1d9b0cf2c08d
+var RankGravity = map[string]float64{"": 1.8}
+
+// Scorer return score of article by its stats and age in hours, higher first
+// gravity is 0 in period, so articles of period are compared by stats only
+type Scorer func(e *RankEntry, hours, gravity float64) float64
+
+// scorers of ranking by name, see RegisterScorer
+var rankScorers = map[string]Scorer{
+	"plus":  func(e *RankEntry, h, g float64) float64 { return decay(float64(e.Plus), h, g) },
+	"minus": func(e *RankEntry, h, g float64) float64 { return decay(float64(e.Minus), h, g) },
+	// many votes of both directions, balanced
+	"controversial": func(e *RankEntry, h, g float64) float64 {
+		if e.Plus == 0 || e.Minus == 0 {
+			return 0
+		}
+		lo, hi := float64(e.Plus), float64(e.Minus)
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		return decay(math.Pow(lo+hi, lo/hi), h, g)
+	},
+	// recent votes, age of article is not counted
+	"rising": func(e *RankEntry, h, g float64) float64 {
+		return float64(e.RecentPlus) - float64(e.RecentMinus)
+	},
+	"discussed": func(e *RankEntry, h, g float64) float64 { return decay(float64(e.Comments), h, g) },
+	"read":      func(e *RankEntry, h, g float64) float64 { return decay(float64(e.Views), h, g) },
+}
+
+// RegisterScorer add ranking by name, must be called on init
+func RegisterScorer(name string, fn Scorer) {
+	if _, ok := rankScorers[name]; ok {
+		panic("tgram: scorer " + name + " registered twice")
+	}
+	rankScorers[name] = fn
+}
+
+// RankScorers return names of rankings, sorted
+func RankScorers() (names []string) {
+	for name := range rankScorers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func decay(v, hours, gravity float64) float64 {
+	if gravity == 0 {
+		return v
+	}
+	return v / math.Pow(hours+2, gravity)
+}
+
+func rankGravity(lang string) float64 {
+	if g, ok := RankGravity[lang]; ok {
+		return g
+	}
+	return RankGravity[""]
+}
+
+// languages with ranked articles, refreshed by RankRefresh
+var rankLangs = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: make(map[string]bool)}
+
+// RankEntry - stats of article for ranking, articles are loaded only for page
+type RankEntry struct {
+	Aid       uint32
+	Author    string
+	CreatedAt time.Time
+	Plus      uint32
+	Minus     uint32
+	Comments  uint32
+	Views     uint32
+	// votes of last RankRising, counted for sorted lists and not stored
+	RecentPlus  uint32
+	RecentMinus uint32
+}
+
+func (e *RankEntry) encode() []byte {
+	b := make([]byte, 24, 24+len(e.Author))
+	binary.BigEndian.PutUint64(b, uint64(e.CreatedAt.Unix()))
+	binary.BigEndian.PutUint32(b[8:], e.Plus)
+	binary.BigEndian.PutUint32(b[12:], e.Minus)
+	binary.BigEndian.PutUint32(b[16:], e.Comments)
+	binary.BigEndian.PutUint32(b[20:], e.Views)
+	return append(b, e.Author...)
+}
+
+func rankDecode(aid uint32, b []byte) (e RankEntry, ok bool) {
+	if len(b) < 24 {
+		return e, false
+	}
+	e.Aid = aid
+	e.CreatedAt = time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+	e.Plus = BintoUint32(b[8:12])
+	e.Minus = BintoUint32(b[12:16])
+	e.Comments = BintoUint32(b[16:20])
+	e.Views = BintoUint32(b[20:24])
+	e.Author = string(b[24:])
+	return e, true
+}
+
+// rankModify update entry of article under lock, missed entry is not created
+func rankModify(lang string, aid uint32, fn func(e *RankEntry)) {
+	f := fmt.Sprintf(dbRank, lang)
+	id32 := Uint32toBin(aid)
+	unlock := lockKey(f, id32)
+	defer unlock()
+	b, err := db.Get(f, id32)
+	if err != nil {
+		return
+	}
+	e, ok := rankDecode(aid, b)
+	if !ok {
+		return
+	}
+	old := e.encode()
+	fn(&e)
+	if b := e.encode(); !bytes.Equal(b, old) {
+		db.Set(f, id32, b)
+		rankInvalidate(lang, e.CreatedAt)
+	}
+}
+
+// rankSync store stats of live article or remove trashed one, called on create,
+// update, trash and restore after article is unlocked
+// article is reloaded under lock of entry, so last call stores last stats
+// return true if article is ranked
+func rankSync(lang string, aid uint32) bool {
+	f := fmt.Sprintf(dbRank, lang)
+	id32 := Uint32toBin(aid)
+	unlock := lockKey(f, id32)
+	defer unlock()
+	var old RankEntry
+	found := false
+	if b, err := db.Get(f, id32); err == nil {
+		old, found = rankDecode(aid, b)
+	}
+	a, err := ArticleGet(lang, ArticleAuthor(lang, aid), aid)
+	if err != nil {
+		if found {
+			db.Delete(f, id32)
+			rankInvalidate(lang, old.CreatedAt)
+		}
+		return false
+	}
+	e := RankEntry{Aid: aid, Author: a.Author, CreatedAt: a.CreatedAt, Plus: a.Plus, Minus: a.Minus}
+	if found {
+		e.Comments, e.Views = old.Comments, old.Views
+	} else {
+		e.Comments = uint32(CommentsCount(lang, aid))
+		if v, err := db.Get(fmt.Sprintf(dbView, lang), id32); err == nil && len(v) == 4 {
+			e.Views = BintoUint32(v)
+		}
+	}
+	// edits of text keep stats and cached lists
+	b := e.encode()
+	if found && bytes.Equal(b, old.encode()) {
+		return true
+	}
+	db.Set(f, id32, b)
+	rankInvalidate(lang, e.CreatedAt)
+	return true
+}
+
+// rankComments change count of comments of article
+func rankComments(lang string, aid uint32, delta int) {
+	rankModify(lang, aid, func(e *RankEntry) {
+		if delta > 0 || e.Comments >= uint32(-delta) {
+			e.Comments = uint32(int(e.Comments) + delta)
+		}
+	})
+}
+
+// rankInvalidate drop cached lists of language with article created at created,
+// they are recomputed on request, zero created drops all lists
+// lists of windows article was out of when they were cached are kept
+func rankInvalidate(lang string, created time.Time) {
+	now := time.Now()
+	for by := range rankScorers {
+		cc.Delete(rankCacheKey(lang, by, ""))
+		for w, d := range RankWindows {
+			if d == 0 || created.IsZero() || created.After(now.Add(-d-RankStore)) {
+				cc.Delete(rankCacheKey(lang, by, w))
+			}
+		}
+	}
+}
+
+func rankCacheKey(lang, by, window string) string {
+	return "rank:" + lang + ":" + by + ":" + window
+}
+
+// rankList return entries sorted by score, cached for RankStore
+func rankList(lang, by, window string) (list []RankEntry) {
+	key := rankCacheKey(lang, by, window)
+	if x, found := cc.Get(key); found {
+		return x.([]RankEntry)
+	}
+	now := time.Now()
+	score := rankScorers[by]
+	gravity := rankGravity(lang)
+	if window != "" {
+		gravity = 0
+	}
+	var since time.Time
+	if d := RankWindows[window]; d > 0 {
+		since = now.Add(-d)
+	}
+	rankLangs.Lock()
+	rankLangs.m[lang] = true
+	rankLangs.Unlock()
+	f := fmt.Sprintf(dbRank, lang)
+	keys, _ := db.Keys(f, nil, 0, 0, false)
+	if len(keys) == 0 {
+		// index of database before ranking
+		if cnt, _ := db.Count(fmt.Sprintf(dbAids, lang)); cnt > 0 {
+			RankRebuild(lang)
+			keys, _ = db.Keys(f, nil, 0, 0, false)
+		}
+	}
+	recent := rankRecent(lang, now)
+	scores := make(map[uint32]float64, len(keys))
+	for _, k := range keys {
+		b, err := db.Get(f, k)
+		if err != nil || len(k) != 4 {
+			continue
+		}
+		e, ok := rankDecode(BintoUint32(k), b)
+		if !ok || e.CreatedAt.Before(since) {
+			continue
+		}
+		e.RecentPlus, e.RecentMinus = recent[e.Aid][0], recent[e.Aid][1]
+		scores[e.Aid] = score(&e, now.Sub(e.CreatedAt).Hours(), gravity)
+		list = append(list, e)
+	}
+	// newest first on same score
+	sort.SliceStable(list, func(i, j int) bool {
+		return scores[list[i].Aid] > scores[list[j].Aid]
+	})
+	cc.Set(key, list, RankStore)
+	return list
+}
+
+// rankRecent count up and down votes on articles of last RankRising,
+// older votes are pruned from log
+func rankRecent(lang string, now time.Time) map[uint32][2]uint32 {
+	recent := make(map[uint32][2]uint32)
+	f := fmt.Sprintf(dbVoteLog, lang, "a")
+	since := now.Add(-RankRising).UnixNano()
+	keys, _ := db.Keys(f, nil, 0, 0, false)
+	for _, k := range keys {
+		if len(k) < 12 {
+			continue
+		}
+		if int64(binary.BigEndian.Uint64(k)) < since {
+			db.Delete(f, k)
+			continue
+		}
+		b, err := db.Get(f, k)
+		if err != nil || len(b) != 1 {
+			continue
+		}
+		aid := BintoUint32(k[8:12])
+		cnt := recent[aid]
+		if b[0] == '+' {
+			cnt[0]++
+		} else {
+			cnt[1]++
+		}
+		recent[aid] = cnt
+	}
+	return recent
+}
+
+// Rank return page of articles sorted by scorer ("plus", "minus", "controversial",
+// "rising", "discussed", "read") in window (day, week, month, all)
+// or with gravity of age if window is empty, and count of ranked
+func Rank(lang, by, window string, page int) (articles []Article, cnt int, err error) {
+	if _, ok := RankWindows[window]; !ok && window != "" {
+		return nil, 0, errors.New("Unknown period")
+	}
+	if _, ok := rankScorers[by]; !ok {
+		return nil, 0, errors.New("Unknown ranking")
+	}
+	list := rankList(lang, by, window)
+	cnt = len(list)
+	from := page * RankPage
+	if page < 0 || from >= cnt {
+		return articles, cnt, nil
+	}
+	to := from + RankPage
+	if to > cnt {
+		to = cnt
+	}
+	for _, e := range list[from:to] {
+		a, err := ArticleGet(lang, e.Author, e.Aid)
+		if err != nil {
+			continue
+		}
+		a.CommentCnt = CommentsCount(lang, a.ID)
+		articles = append(articles, *a)
+	}
+	return articles, cnt, nil
+}
+
+// RankRefresh recompute cached lists of languages, scores with gravity change with time
+// views are counted often, so they are synced here and not on each view
+func RankRefresh() {
+	rankLangs.Lock()
+	langs := make([]string, 0, len(rankLangs.m))
+	for lang := range rankLangs.m {
+		langs = append(langs, lang)
+	}
+	rankLangs.Unlock()
+	for _, lang := range langs {
+		rankViews(lang)
+		rankInvalidate(lang, time.Time{})
+		for by := range rankScorers {
+			rankList(lang, by, "")
+		}
+	}
+}
+
+// rankViews copy counters of views to ranking
+func rankViews(lang string) {
+	f := fmt.Sprintf(dbRank, lang)
+	fView := fmt.Sprintf(dbView, lang)
+	keys, _ := db.Keys(f, nil, 0, 0, true)
+	for _, k := range keys {
+		v, err := db.Get(fView, k)
+		if err != nil || len(v) != 4 || len(k) != 4 {
+			continue
+		}
+		unlock := lockKey(f, k)
+		if b, err := db.Get(f, k); err == nil {
+			if e, ok := rankDecode(BintoUint32(k), b); ok && e.Views != BintoUint32(v) {
+				e.Views = BintoUint32(v)
+				db.Set(f, k, e.encode())
+			}
+		}
+		unlock()
+	}
+}
+
+// RankRebuild build ranking index from live articles, return count of ranked
+func RankRebuild(lang string) (ranked int, err error) {
+	f := fmt.Sprintf(dbRank, lang)
+	old, _ := db.Keys(f, nil, 0, 0, true)
+	for _, k := range old {
+		db.Delete(f, k)
+	}
+	fAids := fmt.Sprintf(dbAids, lang)
+	keys, err := db.Keys(fAids, nil, 0, 0, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if rankSync(lang, BintoUint32(key)) {
+			ranked++
+		}
+	}
+	return ranked, nil
+}
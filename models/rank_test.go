@@ -0,0 +1,119 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestRank(t *testing.T) {
+	defer useMemStorage()()
+	for _, u := range []string{"alice", "bob", "carol"} {
+		models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"})
+	}
+	old, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "old"})
+	models.ArticleModify("tst", "alice", old, func(a *models.Article) error {
+		a.CreatedAt = time.Now().Add(-60 * 24 * time.Hour)
+		return nil
+	})
+	for _, u := range []string{"alice", "bob", "carol"} {
+		models.ArticleVote("tst", u, "alice", old, models.VoteUp)
+	}
+	var aids []uint32
+	for i := 0; i < 25; i++ {
+		aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "bob", Body: "new"})
+		aids = append(aids, aid)
+	}
+	models.ArticleVote("tst", "alice", "bob", aids[3], models.VoteUp)
+	models.CommentNew(&models.Article{Lang: "tst", Author: "alice", Body: "hi"}, "bob", aids[3])
+
+	articles, cnt, err := models.Rank("tst", "plus", "all", 0)
+	if err != nil || cnt != 26 || len(articles) != models.RankPage || articles[0].ID != old {
+		t.Fatalf("want old article first of all, got %d %d %v", cnt, len(articles), err)
+	}
+	if articles[1].ID != aids[3] || articles[1].CommentCnt != 1 {
+		t.Errorf("want voted article second with comment, got %+v", articles[1])
+	}
+	if articles, _, _ = models.Rank("tst", "plus", "all", 1); len(articles) != 6 {
+		t.Errorf("want second page, got %d", len(articles))
+	}
+	if articles, cnt, _ = models.Rank("tst", "plus", "week", 0); cnt != 25 || articles[0].ID != aids[3] {
+		t.Errorf("want old article out of week, got %d", cnt)
+	}
+	if articles, _, _ = models.Rank("tst", "plus", "", 0); articles[0].ID != aids[3] {
+		t.Errorf("want new article first with gravity, got %d", articles[0].ID)
+	}
+	if _, _, err = models.Rank("tst", "plus", "year", 0); err == nil {
+		t.Error("want unknown period")
+	}
+
+	// cached lists of windows with old article are dropped on vote
+	models.Rank("tst", "minus", "all", 0)
+	models.ArticleVote("tst", "bob", "alice", old, models.VoteDown)
+	if articles, _, _ = models.Rank("tst", "minus", "all", 0); articles[0].ID != old || articles[0].Minus != 1 {
+		t.Errorf("want downvoted old article first, got %+v", articles[0])
+	}
+	models.ArticleVote("tst", "bob", "alice", old, models.VoteUp)
+
+	models.ArticleVote("tst", "alice", "bob", aids[0], models.VoteDown)
+	if articles, _, _ = models.Rank("tst", "minus", "all", 0); articles[0].ID != aids[0] {
+		t.Errorf("want downvoted first, got %d", articles[0].ID)
+	}
+	models.ArticleTrash("tst", "bob", aids[0], "bob")
+	if articles, cnt, _ = models.Rank("tst", "minus", "all", 0); cnt != 25 || articles[0].ID == aids[0] {
+		t.Errorf("want trashed article unranked, got %d", cnt)
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+}
+
+func TestRankScorers(t *testing.T) {
+	defer useMemStorage()()
+	for _, u := range []string{"alice", "bob", "carol"} {
+		models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"})
+	}
+	var aids []uint32
+	for i := 0; i < 3; i++ {
+		aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "body"})
+		aids = append(aids, aid)
+	}
+	// first is controversial, second is upvoted and discussed
+	models.ArticleVote("tst", "bob", "alice", aids[0], models.VoteUp)
+	models.ArticleVote("tst", "carol", "alice", aids[0], models.VoteDown)
+	models.ArticleVote("tst", "bob", "alice", aids[1], models.VoteUp)
+	models.ArticleVote("tst", "carol", "alice", aids[1], models.VoteUp)
+	models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "hi"}, "alice", aids[1])
+	models.Rank("tst", "read", "", 0)
+	models.ViewSet("tst", aids[2], 10)
+	time.Sleep(10 * time.Millisecond)
+	models.RankRefresh()
+
+	for by, want := range map[string]uint32{"controversial": aids[0], "rising": aids[1], "discussed": aids[1], "read": aids[2]} {
+		articles, _, err := models.Rank("tst", by, "", 0)
+		if err != nil || len(articles) == 0 || articles[0].ID != want {
+			t.Errorf("%s: want %d first, got %v %v", by, want, articles, err)
+		}
+	}
+	// votes out of rising period are not recent
+	models.RankRising = 50 * time.Millisecond
+	defer func() { models.RankRising = 24 * time.Hour }()
+	time.Sleep(60 * time.Millisecond)
+	models.ArticleVote("tst", "carol", "alice", aids[2], models.VoteUp)
+	if articles, _, _ := models.Rank("tst", "rising", "", 0); articles[0].ID != aids[2] || articles[0].Plus != 1 {
+		t.Errorf("want recently voted first, got %+v", articles[0])
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+	if _, _, err := models.Rank("tst", "random", "", 0); err == nil {
+		t.Error("want unknown ranking")
+	}
+	models.RankGravity["tst"] = 0
+	defer delete(models.RankGravity, "tst")
+	models.RankRefresh()
+	if articles, _, _ := models.Rank("tst", "plus", "", 0); articles[0].Plus != 2 {
+		t.Errorf("want votes without gravity, got %+v", articles[0])
+	}
+}
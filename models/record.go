@@ -0,0 +1,241 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// schema - version of records written, newer binary may not be downgraded
+	dbSchema = "db/schema"
+
+	schemaArticle = "article"
+	schemaUser    = "user"
+	schemaNotify  = "notify"
+)
+
+// schemas of stored records
+var schemas = []string{schemaArticle, schemaUser, schemaNotify}
+
+// recordMagic - prefix of versioned record, gob stream never starts with zero length
+var recordMagic = []byte{0, 't', 'g'}
+
+// Migration upgrade decoded json object of record from version From to From+1
+type Migration struct {
+	From int
+	Up   func(rec map[string]interface{}) error
+}
+
+var migrations = make(map[string][]Migration)
+
+// RegisterMigration add migration of schema from version from, must be called
+// from init in order of versions
+func RegisterMigration(schema string, from int, up func(rec map[string]interface{}) error) {
+	if from != SchemaVersion(schema) {
+		panic(fmt.Sprintf("migration of %s from %d, current version %d", schema, from, SchemaVersion(schema)))
+	}
+	migrations[schema] = append(migrations[schema], Migration{From: from, Up: up})
+}
+
+// SchemaVersion return current version of schema, first version is 1
+func SchemaVersion(schema string) int {
+	return 1 + len(migrations[schema])
+}
+
+// SchemaCheck refuse database written by newer version and mark it with current versions
+func SchemaCheck() error {
+	for _, s := range schemas {
+		b, err := db.Get(dbSchema, []byte(s))
+		if err == nil && len(b) == 4 && int(BintoUint32(b)) > SchemaVersion(s) {
+			return fmt.Errorf("Schema %s version %d is newer than supported %d, upgrade tgram", s, BintoUint32(b), SchemaVersion(s))
+		}
+		if err = db.Set(dbSchema, []byte(s), Uint32toBin(uint32(SchemaVersion(s)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeRecord return versioned json of v
+func encodeRecord(schema string, v interface{}) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, len(recordMagic)+binary.MaxVarintLen64, len(recordMagic)+binary.MaxVarintLen64+len(payload))
+	copy(b, recordMagic)
+	n := binary.PutUvarint(b[len(recordMagic):], uint64(SchemaVersion(schema)))
+	return append(b[:len(recordMagic)+n], payload...), nil
+}
+
+// decodeRecord decode versioned or legacy gob record into v, migrations are applied
+// to object in field of record or to record itself if field is empty
+// stale is true if record is not in current version
+func decodeRecord(schema, field string, b []byte, v interface{}) (stale bool, err error) {
+	if !bytes.HasPrefix(b, recordMagic) {
+		return true, gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+	}
+	version, n := binary.Uvarint(b[len(recordMagic):])
+	if n <= 0 || version == 0 {
+		return false, errors.New("Bad record version")
+	}
+	payload := b[len(recordMagic)+n:]
+	current := SchemaVersion(schema)
+	if int(version) > current {
+		return false, fmt.Errorf("Record of %s version %d is newer than supported %d", schema, version, current)
+	}
+	if int(version) < current {
+		var rec map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.UseNumber()
+		if err = dec.Decode(&rec); err != nil {
+			return true, err
+		}
+		obj := rec
+		if field != "" {
+			if obj, _ = rec[field].(map[string]interface{}); obj == nil {
+				return true, fmt.Errorf("Record of %s has no %s", schema, field)
+			}
+		}
+		for _, m := range migrations[schema][version-1:] {
+			if err = m.Up(obj); err != nil {
+				return true, err
+			}
+		}
+		if payload, err = json.Marshal(rec); err != nil {
+			return true, err
+		}
+	}
+	return int(version) < current, json.Unmarshal(payload, v)
+}
+
+// recordSet store v as record of schema
+func recordSet(file string, key []byte, schema string, v interface{}) error {
+	b, err := encodeRecord(schema, v)
+	if err != nil {
+		return err
+	}
+	return db.Set(file, key, b)
+}
+
+// recordGet load record of schema into v, see decodeRecord
+func recordGet(file string, key []byte, schema, field string, v interface{}) error {
+	b, err := db.Get(file, key)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return errors.New("Not found")
+	}
+	_, err = decodeRecord(schema, field, b, v)
+	return err
+}
+
+// userRecord - stored fields of User, json tags of User hide them from api
+type userRecord struct {
+	Username       string
+	Email          string
+	Password       string `json:"-"`
+	NewPassword    string `json:"-"`
+	Bio            string
+	Image          string
+	Lang           string
+	PasswordHash   string
+	LastPost       uint32
+	Unseen         uint32
+	IP             string
+	NoJs           bool
+	Type2Telegram  string
+	Type2TeleNoTxt bool
+	NoCommentMail  bool
+	FollowComments bool
+	Created        int64
+}
+
+func userSet(u *User) error {
+	return recordSet(fmt.Sprintf(dbUser, u.Lang), []byte(u.Username), schemaUser, userRecord(*u))
+}
+
+func userGet(lang string, username []byte) (u *User, err error) {
+	u = &User{}
+	if err = recordGet(fmt.Sprintf(dbUser, lang), username, schemaUser, "", (*userRecord)(u)); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// RecordsMigrate rewrite stale records of language in current version
+// return count of rewritten records
+func RecordsMigrate(lang string) (migrated int, err error) {
+	rewrite := func(f string, key []byte, schema, field string, v interface{}) error {
+		b, err := db.Get(f, key)
+		if err != nil || b == nil {
+			return err
+		}
+		stale, err := decodeRecord(schema, field, b, v)
+		if err != nil {
+			return fmt.Errorf("%s %v: %v", f, key, err)
+		}
+		if !stale {
+			return nil
+		}
+		migrated++
+		return recordSet(f, key, schema, v)
+	}
+
+	users, err := db.Keys(fmt.Sprintf(dbUser, lang), nil, 0, 0, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range users {
+		if err = rewrite(fmt.Sprintf(dbUser, lang), u, schemaUser, "", &userRecord{}); err != nil {
+			return migrated, err
+		}
+
+		fAUser := fmt.Sprintf(dbAUser, lang, u)
+		keys, _ := db.Keys(fAUser, nil, 0, 0, true)
+		for _, k := range keys {
+			unlock := lockKey(fAUser, k)
+			err := rewrite(fAUser, k, schemaArticle, "", &Article{})
+			unlock()
+			if err != nil {
+				return migrated, err
+			}
+		}
+		fNotify := fmt.Sprintf(dbNotify, lang, u)
+		keys, _ = db.Keys(fNotify, nil, 0, 0, true)
+		for _, k := range keys {
+			if err = rewrite(fNotify, k, schemaNotify, "", &Notification{}); err != nil {
+				return migrated, err
+			}
+		}
+		for f, fn := range map[string]func() interface{}{
+			fmt.Sprintf(dbTrash, lang, u): func() interface{} { return &Trashed{} },
+			fmt.Sprintf(dbDraft, lang, u): func() interface{} { return &Draft{} },
+		} {
+			keys, _ := db.Keys(f, nil, 0, 0, true)
+			for _, k := range keys {
+				if err = rewrite(f, k, schemaArticle, "Article", fn()); err != nil {
+					return migrated, err
+				}
+			}
+		}
+	}
+	for f, field := range map[string]string{fmt.Sprintf(dbComment, lang): "", fmt.Sprintf(dbRevision, lang): "Article"} {
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			var v interface{} = &Article{}
+			if field != "" {
+				v = &Revision{}
+			}
+			if err = rewrite(f, k, schemaArticle, field, v); err != nil {
+				return migrated, err
+			}
+		}
+	}
+	return migrated, nil
+}
@@ -0,0 +1,71 @@
+package models_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestRecordMigrations(t *testing.T) {
+	defer useMemStorage()()
+
+	// record stored before versioning
+	legacy := models.User{Lang: "tst", Username: "old", Bio: "gob"}
+	if err := models.GetStorage().SetGob("db/tst/user", []byte("old"), legacy); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := models.UserGet("tst", "old"); err != nil || u.Bio != "gob" {
+		t.Fatalf("want legacy user, got %+v %v", u, err)
+	}
+	models.UserNew(&models.User{Lang: "tst", Username: "alice", Password: "password", Bio: "bio"})
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "article body"})
+
+	version := models.SchemaVersion("user")
+	models.RegisterMigration("user", version, func(rec map[string]interface{}) error {
+		rec["Bio"] = strings.ToUpper(rec["Bio"].(string))
+		return nil
+	})
+	if models.SchemaVersion("user") != version+1 {
+		t.Fatal("want schema version incremented")
+	}
+	if u, _ := models.UserGet("tst", "alice"); u.Bio != "BIO" {
+		t.Errorf("want user upgraded on read, got %q", u.Bio)
+	}
+	if _, err := models.UserCheckGet("tst", "alice", "password"); err != nil {
+		t.Errorf("want password hash kept, got %v", err)
+	}
+
+	// legacy and old version users are rewritten, articles are current
+	migrated, err := models.RecordsMigrate("tst")
+	if err != nil || migrated != 2 {
+		t.Errorf("want 2 records migrated, got %d %v", migrated, err)
+	}
+	if migrated, _ = models.RecordsMigrate("tst"); migrated != 0 {
+		t.Errorf("want nothing to migrate, got %d", migrated)
+	}
+	if u, _ := models.UserGet("tst", "alice"); u.Bio != "BIO" {
+		t.Errorf("want migration applied once, got %q", u.Bio)
+	}
+	if a, err := models.ArticleGet("tst", "alice", aid); err != nil || a.Body != "article body" {
+		t.Errorf("want article, got %+v %v", a, err)
+	}
+
+	if err = models.SchemaCheck(); err != nil {
+		t.Fatal(err)
+	}
+	models.GetStorage().Set("db/schema", []byte("user"), models.Uint32toBin(uint32(version+2)))
+	if err = models.SchemaCheck(); err == nil {
+		t.Error("want newer schema refused")
+	}
+}
+
+func TestRecordBadVersion(t *testing.T) {
+	defer useMemStorage()()
+
+	// versioned record prefix with version 0
+	models.GetStorage().Set("db/tst/user", []byte("zero"), []byte("\x00tg\x00{}"))
+	if _, err := models.UserGet("tst", "zero"); err == nil {
+		t.Error("want bad version refused")
+	}
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// aid+username:key - record of user about article: m - mention, n - notification
+	dbRefs = "db/%s/r%s"
+	// id+username - votes of ledger by article or comment, see dbVote
+	dbVoteIDs = "db/%s/%svid"
+)
+
+func refKey(aid uint32, username string, key []byte) []byte {
+	k := append(Uint32toBin(aid), username+":"...)
+	return append(k, key...)
+}
+
+// refAdd index record of user by article
+func refAdd(lang, cat string, aid uint32, username string, key []byte) {
+	db.Set(fmt.Sprintf(dbRefs, lang, cat), refKey(aid, username, key), nil)
+}
+
+// refRemove remove record of user with index of it
+func refRemove(lang, cat, username string, key []byte) {
+	if aid := refAid(lang, cat, username, key); aid != 0 {
+		db.Delete(fmt.Sprintf(dbRefs, lang, cat), refKey(aid, username, key))
+	}
+	db.Delete(refFile(lang, cat, username), key)
+	if cat == "n" {
+		db.Delete(fmt.Sprintf(dbNotifyUnread, lang, username), key)
+	}
+}
+
+// refFile return keyspace of records of user
+func refFile(lang, cat, username string) string {
+	if cat == "m" {
+		return fmt.Sprintf(dbMention, lang, username)
+	}
+	return fmt.Sprintf(dbNotify, lang, username)
+}
+
+// refAid return article of record of user, 0 if record not found
+func refAid(lang, cat, username string, key []byte) uint32 {
+	f := refFile(lang, cat, username)
+	if cat == "m" {
+		var m Mention
+		if db.GetGob(f, key, &m) != nil {
+			return 0
+		}
+		return m.Aid
+	}
+	var n Notification
+	if recordGet(f, key, schemaNotify, "", &n) != nil {
+		return 0
+	}
+	return n.Aid
+}
+
+// refsDelete remove records about article and index of them
+func refsDelete(lang, cat string, aid uint32) {
+	f := fmt.Sprintf(dbRefs, lang, cat)
+	keys, _ := db.Keys(f, append(Uint32toBin(aid), '*'), 0, 0, true)
+	for _, k := range keys {
+		if i := bytes.IndexByte(k[4:], ':'); i >= 0 {
+			username, key := string(k[4:4+i]), k[5+i:]
+			if refAid(lang, cat, username, key) == aid {
+				refRemove(lang, cat, username, key)
+			}
+		}
+		db.Delete(f, k)
+	}
+}
+
+func voteIDKey(username string, id uint32) []byte {
+	return append(Uint32toBin(id), username...)
+}
+
+// RefsRebuild index mentions, notifications and votes by articles
+// and comments, return count of indexed records
+func RefsRebuild(lang string) (indexed int, err error) {
+	users, err := db.Keys(fmt.Sprintf(dbUser, lang), nil, 0, 0, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range users {
+		f := fmt.Sprintf(dbMention, lang, u)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			var m Mention
+			if db.GetGob(f, k, &m) == nil {
+				refAdd(lang, "m", m.Aid, string(u), k)
+				indexed++
+			}
+		}
+		f = fmt.Sprintf(dbNotify, lang, u)
+		keys, _ = db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			var n Notification
+			if recordGet(f, k, schemaNotify, "", &n) == nil && n.Aid != 0 {
+				refAdd(lang, "n", n.Aid, string(u), k)
+				indexed++
+			}
+		}
+	}
+	for _, cat := range []string{"a", "c"} {
+		f := fmt.Sprintf(dbVote, lang, cat)
+		keys, _ := db.Keys(f, nil, 0, 0, true)
+		for _, k := range keys {
+			if len(k) < 5 {
+				continue
+			}
+			// time of indexed vote is kept
+			fIDs, key := fmt.Sprintf(dbVoteIDs, lang, cat), voteIDKey(string(k[:len(k)-5]), BintoUint32(k[len(k)-4:]))
+			if has, _ := db.Has(fIDs, key); !has {
+				db.Set(fIDs, key, nil)
+			}
+			indexed++
+		}
+	}
+	return indexed, nil
+}
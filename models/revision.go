@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// aid/rev - previous versions of article
+	dbRevision = "db/%s/rev"
+
+	// RevisionsMax - max stored revisions of article, oldest are removed
+	RevisionsMax = 100
+)
+
+// Revision - previous version of article
+type Revision struct {
+	Rev     uint32
+	SavedAt time.Time // when this version was published or edited
+	Article Article
+}
+
+func revisionKey(aid, rev uint32) []byte {
+	return append(Uint32toBin(aid), Uint32toBin(rev)...)
+}
+
+// contentChanged return true if text of article was changed
+func contentChanged(old, a *Article) bool {
+	return old.Title != a.Title || old.Body != a.Body || old.OgImage != a.OgImage ||
+		strings.Join(old.TagList(), " ") != strings.Join(a.TagList(), " ")
+}
+
+// revisionKeys return sorted keys of article revisions
+func revisionKeys(lang string, aid uint32) [][]byte {
+	keys, _ := db.Keys(fmt.Sprintf(dbRevision, lang), append(Uint32toBin(aid), '*'), 0, 0, true)
+	return keys
+}
+
+// revisionNew store old version of article, called under article lock
+func revisionNew(lang string, old *Article) (err error) {
+	f := fmt.Sprintf(dbRevision, lang)
+	keys := revisionKeys(lang, old.ID)
+	var rev uint32 = 1
+	if len(keys) > 0 {
+		rev = BintoUint32(keys[len(keys)-1][4:]) + 1
+	}
+	r := Revision{Rev: rev, SavedAt: old.CreatedAt, Article: *old}
+	if !old.UpdatedAt.IsZero() {
+		r.SavedAt = old.UpdatedAt
+	}
+	r.Article.Comments = nil
+	if err = recordSet(f, revisionKey(old.ID, rev), schemaArticle, r); err != nil {
+		return err
+	}
+	for i := 0; i+RevisionsMax <= len(keys); i++ {
+		db.Delete(f, keys[i])
+	}
+	return nil
+}
+
+// Revisions return previous versions of article, newest first
+func Revisions(lang string, aid uint32) (revs []Revision) {
+	f := fmt.Sprintf(dbRevision, lang)
+	keys := revisionKeys(lang, aid)
+	for i := len(keys) - 1; i >= 0; i-- {
+		var r Revision
+		if err := recordGet(f, keys[i], schemaArticle, "Article", &r); err != nil {
+			continue
+		}
+		revs = append(revs, r)
+	}
+	return revs
+}
+
+// RevisionGet return revision of article
+func RevisionGet(lang string, aid, rev uint32) (r *Revision, err error) {
+	if err = recordGet(fmt.Sprintf(dbRevision, lang), revisionKey(aid, rev), schemaArticle, "Article", &r); err != nil {
+		return nil, errors.New("Revision not found")
+	}
+	return r, nil
+}
+
+// RevisionRestore replace text of article with revision
+// current version is kept as new revision
+func RevisionRestore(lang, username string, aid, rev uint32) (a *Article, err error) {
+	r, err := RevisionGet(lang, aid, rev)
+	if err != nil {
+		return nil, err
+	}
+	return ArticleModify(lang, username, aid, func(a *Article) error {
+		old := r.Article
+		if !contentChanged(a, &old) {
+			return errors.New("Revision is same as current version")
+		}
+		a.Title = old.Title
+		a.Body = old.Body
+		a.HTML = old.HTML
+		a.OgImage = old.OgImage
+		a.ReadingTime = old.ReadingTime
+		a.WordCount = old.WordCount
+		a.SetTags(old.TagList())
+		return nil
+	})
+}
+
+// revisionsDelete remove all revisions of article
+func revisionsDelete(lang string, aid uint32) {
+	f := fmt.Sprintf(dbRevision, lang)
+	for _, k := range revisionKeys(lang, aid) {
+		db.Delete(f, k)
+	}
+}
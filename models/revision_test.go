@@ -0,0 +1,59 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestRevisions(t *testing.T) {
+	defer useMemStorage()()
+
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Title: "v1", Body: "first body"})
+	edit := func(title string) {
+		t.Helper()
+		if _, err := models.ArticleModify("tst", "alice", aid, func(a *models.Article) error {
+			a.Title = title
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	edit("v2")
+	edit("v3")
+	// votes do not make revisions
+	if _, err := models.ArticleVote("tst", "bob", "alice", aid, models.VoteUp); err != nil {
+		t.Fatal(err)
+	}
+	revs := models.Revisions("tst", aid)
+	if len(revs) != 2 || revs[0].Rev != 2 || revs[0].Article.Title != "v2" || revs[1].Article.Title != "v1" {
+		t.Fatalf("want revisions v2, v1, got %v", revs)
+	}
+	a, _ := models.ArticleGet("tst", "alice", aid)
+	if a.UpdatedAt.IsZero() || a.Plus != 1 {
+		t.Errorf("want edited article with vote, got %v", a)
+	}
+
+	a, err := models.RevisionRestore("tst", "alice", aid, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Title != "v1" || a.Plus != 1 {
+		t.Errorf("want restored v1 with vote, got %v", a)
+	}
+	if revs = models.Revisions("tst", aid); len(revs) != 3 || revs[0].Article.Title != "v3" {
+		t.Errorf("want current version kept as revision, got %v", revs)
+	}
+	if _, err = models.RevisionRestore("tst", "alice", aid, 1); err == nil {
+		t.Error("want error on restore of same version")
+	}
+
+	models.ArticleDelete("tst", "alice", aid)
+	if revs = models.Revisions("tst", aid); len(revs) != 3 {
+		t.Errorf("want revisions kept in trash, got %v", revs)
+	}
+	models.ArticlePurge("tst", "alice", aid)
+	if revs = models.Revisions("tst", aid); len(revs) != 0 {
+		t.Errorf("want revisions removed with article, got %v", revs)
+	}
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// term:aid - weighted term frequency
+	dbSearch = "db/%s/search"
+	// aid - searchDoc
+	dbSearchDoc = "db/%s/searchdoc"
+
+	// SearchPage - results per page
+	SearchPage = 20
+
+	// title terms weight more then body terms
+	searchTitleWeight = 3
+	searchMaxTerm     = 32
+)
+
+// searchDoc - indexed terms of article, used for reindex and removal
+type searchDoc struct {
+	Author string
+	Hash   uint64
+	Terms  []string
+	Len    int
+}
+
+// cjkLangs - languages without spaces between words, tokenized by bigrams
+var cjkLangs = map[string]bool{"zh": true, "ja": true, "ko": true}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana)
+}
+
+// Tokenize split text to lowercase search terms
+// words are split on non letters and digits, runs of CJK chars are split
+// to overlapping bigrams for zh, ja and ko or kept as words for other languages
+func Tokenize(lang, text string) (terms []string) {
+	bigrams := cjkLangs[lang]
+	var word []rune
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if bigrams && isCJK(word[0]) {
+			if len(word) == 1 {
+				terms = append(terms, string(word))
+			}
+			for i := 0; i+1 < len(word); i++ {
+				terms = append(terms, string(word[i:i+2]))
+			}
+		} else if len(word) > 1 && len(word) <= searchMaxTerm {
+			terms = append(terms, string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		// CJK and other scripts are different words: "go语言" - "go", "语言"
+		if len(word) > 0 && isCJK(r) != isCJK(word[len(word)-1]) {
+			flush()
+		}
+		word = append(word, r)
+	}
+	flush()
+	return terms
+}
+
+func searchKey(term string, aid uint32) []byte {
+	return append([]byte(term+":"), Uint32toBin(aid)...)
+}
+
+func searchHash(a *Article) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(a.Author + "\x00" + a.Title + "\x00" + a.Body))
+	return h.Sum64()
+}
+
+// SearchIndex add or replace article in search index
+func SearchIndex(a *Article) (err error) {
+	fDoc := fmt.Sprintf(dbSearchDoc, a.Lang)
+	var old searchDoc
+	if db.GetGob(fDoc, Uint32toBin(a.ID), &old) == nil && old.Hash == searchHash(a) {
+		// votes and comments do not change text
+		return nil
+	}
+	SearchRemove(a.Lang, a.ID)
+
+	freq := make(map[string]uint32)
+	for _, t := range Tokenize(a.Lang, a.Title) {
+		freq[t] += searchTitleWeight
+	}
+	body := Tokenize(a.Lang, a.Body)
+	for _, t := range body {
+		freq[t]++
+	}
+	doc := searchDoc{Author: a.Author, Hash: searchHash(a), Len: len(body)}
+	f := fmt.Sprintf(dbSearch, a.Lang)
+	for t, n := range freq {
+		if err = db.Set(f, searchKey(t, a.ID), Uint32toBin(n)); err != nil {
+			return err
+		}
+		doc.Terms = append(doc.Terms, t)
+	}
+	return db.SetGob(fDoc, Uint32toBin(a.ID), doc)
+}
+
+// SearchRemove remove article from search index
+func SearchRemove(lang string, aid uint32) {
+	fDoc := fmt.Sprintf(dbSearchDoc, lang)
+	var doc searchDoc
+	if err := db.GetGob(fDoc, Uint32toBin(aid), &doc); err != nil {
+		return
+	}
+	f := fmt.Sprintf(dbSearch, lang)
+	for _, t := range doc.Terms {
+		db.Delete(f, searchKey(t, aid))
+	}
+	db.Delete(fDoc, Uint32toBin(aid))
+}
+
+// Search return page of articles ranked by tf-idf and count of all found
+func Search(lang, query string, page int) (articles []Article, cnt int, err error) {
+	terms := Tokenize(lang, query)
+	if len(terms) == 0 {
+		return articles, 0, errors.New("Nothing to search")
+	}
+	fDoc := fmt.Sprintf(dbSearchDoc, lang)
+	total, _ := db.Count(fDoc)
+	if total == 0 {
+		return articles, 0, nil
+	}
+	f := fmt.Sprintf(dbSearch, lang)
+	scores := make(map[uint32]float64)
+	matched := make(map[uint32]int)
+	seen := make(map[string]bool)
+	for _, t := range terms {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		keys, _ := db.Keys(f, []byte(t+":*"), 0, 0, true)
+		if len(keys) == 0 {
+			continue
+		}
+		idf := math.Log(1 + float64(total)/float64(len(keys)))
+		for _, k := range keys {
+			b, err := db.Get(f, k)
+			if err != nil || len(b) != 4 {
+				continue
+			}
+			aid := BintoUint32(k[len(k)-4:])
+			scores[aid] += (1 + math.Log(float64(BintoUint32(b)))) * idf
+			matched[aid]++
+		}
+	}
+	type found struct {
+		aid   uint32
+		score float64
+	}
+	var res []found
+	for aid, score := range scores {
+		// articles with all terms first
+		res = append(res, found{aid, score * float64(matched[aid]) / float64(len(seen))})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].score == res[j].score {
+			return res[i].aid > res[j].aid
+		}
+		return res[i].score > res[j].score
+	})
+	cnt = len(res)
+	from := page * SearchPage
+	if page < 0 || from >= cnt {
+		return articles, cnt, nil
+	}
+	to := from + SearchPage
+	if to > cnt {
+		to = cnt
+	}
+	for _, r := range res[from:to] {
+		var doc searchDoc
+		if err := db.GetGob(fDoc, Uint32toBin(r.aid), &doc); err != nil {
+			continue
+		}
+		a, err := ArticleGet(lang, doc.Author, r.aid)
+		if err != nil {
+			continue
+		}
+		a.CommentCnt = CommentsCount(lang, a.ID)
+		articles = append(articles, *a)
+	}
+	return articles, cnt, nil
+}
+
+// SearchReindex index all articles of language, return count of indexed
+func SearchReindex(lang string) (indexed int, err error) {
+	fAids := fmt.Sprintf(dbAids, lang)
+	keys, err := db.Keys(fAids, nil, 0, 0, true)
+	if err != nil {
+		return indexed, err
+	}
+	for _, key := range keys {
+		author, err := db.Get(fAids, key)
+		if err != nil {
+			continue
+		}
+		a, err := ArticleGet(lang, string(author), BintoUint32(key))
+		if err != nil {
+			continue
+		}
+		a.Lang = lang
+		if err = SearchIndex(a); err != nil {
+			return indexed, err
+		}
+		indexed++
+	}
+	return indexed, nil
+}
@@ -0,0 +1,76 @@
+package models_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		lang, text string
+		want       []string
+	}{
+		{"en", "Hello, World! a Go-lang 2018", []string{"hello", "world", "go", "lang", "2018"}},
+		{"ru", "Привет, мир", []string{"привет", "мир"}},
+		{"zh", "学习go语言", []string{"学习", "go", "语言"}},
+		{"zh", "中文分词", []string{"中文", "文分", "分词"}},
+		{"ko", "한국어 검색", []string{"한국", "국어", "검색"}},
+		{"en", "中文", []string{"中文"}},
+	}
+	for _, c := range cases {
+		if got := models.Tokenize(c.lang, c.text); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %q: want %q, got %q", c.lang, c.text, c.want, got)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	defer useMemStorage()()
+
+	a1, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Title: "Golang storage", Body: "about key value storage"})
+	a2, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "bob", Title: "Cats", Body: "cats and golang"})
+	a3, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "bob", Title: "Dogs", Body: "nothing interesting here"})
+
+	ids := func(q string) (ids []uint32) {
+		t.Helper()
+		res, cnt, err := models.Search("tst", q, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cnt != len(res) {
+			t.Errorf("want count %d, got %d", len(res), cnt)
+		}
+		for _, a := range res {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+	if got := ids("golang"); !reflect.DeepEqual(got, []uint32{a1, a2}) {
+		t.Errorf("want title match first, got %v", got)
+	}
+	if got := ids("cats golang"); len(got) != 2 || got[0] != a2 {
+		t.Errorf("want article with all terms first, got %v", got)
+	}
+
+	// update reindex, delete remove
+	if _, err := models.ArticleModify("tst", "bob", a3, func(a *models.Article) error {
+		a.Body = "golang dogs"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids("interesting"); len(got) != 0 {
+		t.Errorf("want old terms removed, got %v", got)
+	}
+	if err := models.ArticleDelete("tst", "alice", a1); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids("golang"); len(got) != 2 || got[0] == a1 || got[1] == a1 {
+		t.Errorf("want deleted article removed, got %v", got)
+	}
+	if _, _, err := models.Search("tst", "!", 0); err == nil {
+		t.Error("want error on empty query")
+	}
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"path/filepath"
+	"sync"
+
+	sp "github.com/recoilme/slowpoke"
+)
+
+// Storage - key/value backend used by models
+// file is a keyspace name like "db/en/user", keys inside it are sorted bytewise
+// Keys follows slowpoke rules: from is excluded, from with trailing '*' is a prefix
+type Storage interface {
+	Set(file string, key, val []byte) error
+	Get(file string, key []byte) ([]byte, error)
+	SetGob(file string, key interface{}, val interface{}) error
+	GetGob(file string, key interface{}, val interface{}) error
+	Has(file string, key []byte) (bool, error)
+	Delete(file string, key []byte) (bool, error)
+	Keys(file string, from []byte, limit, offset uint32, asc bool) ([][]byte, error)
+	Count(file string) (uint64, error)
+	Counter(file string, key []byte) (uint64, error)
+	CloseAll() error
+}
+
+var (
+	db   Storage = SlowpokeStorage{}
+	root         = "."
+
+	// slowpoke counter is get and set, not atomic
+	counterMu sync.Mutex
+)
+
+// SetRoot set data root for db, img and ava dirs
+func SetRoot(dir string) {
+	root = dir
+}
+
+// DataPath return path inside data root, absolute paths returned as is
+func DataPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
+
+// SetStorage replace storage backend, call it before serving requests
+func SetStorage(s Storage) {
+	db = s
+}
+
+// GetStorage return current storage backend
+func GetStorage() Storage {
+	return db
+}
+
+// Close flush and close storage
+func Close() error {
+	return db.CloseAll()
+}
+
+// SlowpokeStorage - disk storage on slowpoke files inside data root
+type SlowpokeStorage struct{}
+
+// Set store val by key
+func (SlowpokeStorage) Set(file string, key, val []byte) error {
+	return sp.Set(DataPath(file), key, val)
+}
+
+// Get return val by key
+func (SlowpokeStorage) Get(file string, key []byte) ([]byte, error) {
+	return sp.Get(DataPath(file), key)
+}
+
+// SetGob store gob encoded val
+func (SlowpokeStorage) SetGob(file string, key interface{}, val interface{}) error {
+	return sp.SetGob(DataPath(file), key, val)
+}
+
+// GetGob decode stored val
+func (SlowpokeStorage) GetGob(file string, key interface{}, val interface{}) error {
+	return sp.GetGob(DataPath(file), key, val)
+}
+
+// Has return true if key exists
+func (SlowpokeStorage) Has(file string, key []byte) (bool, error) {
+	return sp.Has(DataPath(file), key)
+}
+
+// Delete remove key
+func (SlowpokeStorage) Delete(file string, key []byte) (bool, error) {
+	return sp.Delete(DataPath(file), key)
+}
+
+// Keys return sorted keys
+func (SlowpokeStorage) Keys(file string, from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	return sp.Keys(DataPath(file), from, limit, offset, asc)
+}
+
+// Count return count of keys
+func (SlowpokeStorage) Count(file string) (uint64, error) {
+	return sp.Count(DataPath(file))
+}
+
+// Counter return incremented counter
+func (SlowpokeStorage) Counter(file string, key []byte) (uint64, error) {
+	counterMu.Lock()
+	defer counterMu.Unlock()
+	return sp.Counter(DataPath(file), key)
+}
+
+// CloseAll close all opened files
+func (SlowpokeStorage) CloseAll() error {
+	return sp.CloseAll()
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrKeyNotFound returned by MemStorage for missing keys
+var ErrKeyNotFound = errors.New("Error: key not found")
+
+// MemStorage - in-memory storage for tests and ephemeral instances
+// nothing is written on disk, all data is lost on exit
+type MemStorage struct {
+	sync.RWMutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	keys [][]byte
+	vals map[string][]byte
+}
+
+// NewMemStorage create empty in-memory storage
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: make(map[string]*memFile)}
+}
+
+// gobKey encode key like slowpoke: []byte as is, anything else with gob
+func gobKey(key interface{}) ([]byte, error) {
+	if b, ok := key.([]byte); ok {
+		return b, nil
+	}
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(key)
+	return buf.Bytes(), err
+}
+
+func (m *MemStorage) file(file string, create bool) *memFile {
+	f, ok := m.files[file]
+	if !ok && create {
+		f = &memFile{vals: make(map[string][]byte)}
+		m.files[file] = f
+	}
+	return f
+}
+
+// Set store val by key
+func (m *MemStorage) Set(file string, key, val []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.set(file, key, val)
+	return nil
+}
+
+func (m *MemStorage) set(file string, key, val []byte) {
+	f := m.file(file, true)
+	v := make([]byte, len(val))
+	copy(v, val)
+	if _, ok := f.vals[string(key)]; !ok {
+		k := make([]byte, len(key))
+		copy(k, key)
+		i := sort.Search(len(f.keys), func(i int) bool {
+			return bytes.Compare(f.keys[i], k) >= 0
+		})
+		f.keys = append(f.keys, nil)
+		copy(f.keys[i+1:], f.keys[i:])
+		f.keys[i] = k
+	}
+	f.vals[string(key)] = v
+}
+
+// Get return val by key
+func (m *MemStorage) Get(file string, key []byte) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	f := m.file(file, false)
+	if f == nil {
+		return nil, ErrKeyNotFound
+	}
+	v, ok := f.vals[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	b := make([]byte, len(v))
+	copy(b, v)
+	return b, nil
+}
+
+// SetGob store gob encoded val
+func (m *MemStorage) SetGob(file string, key interface{}, val interface{}) error {
+	k, err := gobKey(key)
+	if err != nil {
+		return err
+	}
+	if b, ok := val.([]byte); ok {
+		return m.Set(file, k, b)
+	}
+	buf := bytes.Buffer{}
+	if err = gob.NewEncoder(&buf).Encode(val); err != nil {
+		return err
+	}
+	return m.Set(file, k, buf.Bytes())
+}
+
+// GetGob decode stored val
+func (m *MemStorage) GetGob(file string, key interface{}, val interface{}) error {
+	k, err := gobKey(key)
+	if err != nil {
+		return err
+	}
+	b, err := m.Get(file, k)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(val)
+}
+
+// Has return true if key exists
+func (m *MemStorage) Has(file string, key []byte) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+	f := m.file(file, false)
+	if f == nil {
+		return false, nil
+	}
+	_, ok := f.vals[string(key)]
+	return ok, nil
+}
+
+// Delete remove key
+func (m *MemStorage) Delete(file string, key []byte) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	f := m.file(file, false)
+	if f == nil {
+		return true, nil
+	}
+	if _, ok := f.vals[string(key)]; !ok {
+		return true, nil
+	}
+	delete(f.vals, string(key))
+	i := sort.Search(len(f.keys), func(i int) bool {
+		return bytes.Compare(f.keys[i], key) >= 0
+	})
+	if i < len(f.keys) && bytes.Equal(f.keys[i], key) {
+		f.keys = append(f.keys[:i], f.keys[i+1:]...)
+	}
+	return true, nil
+}
+
+// Keys return keys in ascending or descending order
+// if limit == 0 return all keys, offset skip records
+// from is excluded, from with trailing '*' return keys with prefix
+func (m *MemStorage) Keys(file string, from []byte, limit, offset uint32, asc bool) ([][]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	res := make([][]byte, 0)
+	f := m.file(file, false)
+	if f == nil {
+		if len(from) > 1 && from[len(from)-1] == '*' {
+			return res, ErrKeyNotFound
+		}
+		return res, nil
+	}
+	keys := f.keys
+	if len(from) > 1 && from[len(from)-1] == '*' {
+		prefix := from[:len(from)-1]
+		found := -1
+		if asc {
+			found = sort.Search(len(keys), func(i int) bool {
+				return bytes.Compare(keys[i], prefix) >= 0
+			})
+		} else {
+			for j := len(keys) - 1; j >= 0; j-- {
+				if bytes.HasPrefix(keys[j], prefix) {
+					found = j
+					break
+				}
+			}
+		}
+		if found < 0 || found >= len(keys) || !bytes.HasPrefix(keys[found], prefix) {
+			return res, ErrKeyNotFound
+		}
+		start, end := keysInterval(found, int(limit), int(offset), 0, len(keys), asc)
+		if start < 0 || start >= len(keys) {
+			return res, nil
+		}
+		if asc {
+			for i := start; i <= end && bytes.HasPrefix(keys[i], prefix); i++ {
+				res = append(res, copyKey(keys[i]))
+			}
+		} else {
+			for i := start; i >= end && bytes.HasPrefix(keys[i], prefix); i-- {
+				res = append(res, copyKey(keys[i]))
+			}
+		}
+		return res, nil
+	}
+
+	find := -1
+	excludeFrom := 0
+	if from == nil {
+		if asc {
+			find = 0
+		} else {
+			find = len(keys) - 1
+		}
+	} else {
+		excludeFrom = 1
+		i := sort.Search(len(keys), func(i int) bool {
+			return bytes.Compare(keys[i], from) >= 0
+		})
+		if i < len(keys) && bytes.Equal(keys[i], from) {
+			find = i
+		}
+	}
+	start, end := keysInterval(find, int(limit), int(offset), excludeFrom, len(keys), asc)
+	if start < 0 || start >= len(keys) {
+		return res, nil
+	}
+	if asc {
+		for i := start; i <= end; i++ {
+			res = append(res, copyKey(keys[i]))
+		}
+	} else {
+		for i := start; i >= end; i-- {
+			res = append(res, copyKey(keys[i]))
+		}
+	}
+	return res, nil
+}
+
+// keysInterval same as in pudge
+func keysInterval(find, limit, offset, excludeFrom, len int, asc bool) (int, int) {
+	end := 0
+	start := find
+
+	if asc {
+		start += (offset + excludeFrom)
+		if limit == 0 {
+			end = len - excludeFrom
+		} else {
+			end = (start + limit - 1)
+		}
+	} else {
+		start -= (offset + excludeFrom)
+		if limit == 0 {
+			end = 0
+		} else {
+			end = start - limit + 1
+		}
+	}
+
+	if end < 0 {
+		end = 0
+	}
+	if end >= len {
+		end = len - 1
+	}
+	return start, end
+}
+
+func copyKey(k []byte) []byte {
+	b := make([]byte, len(k))
+	copy(b, k)
+	return b
+}
+
+// Count return count of keys
+func (m *MemStorage) Count(file string) (uint64, error) {
+	m.RLock()
+	defer m.RUnlock()
+	f := m.file(file, false)
+	if f == nil {
+		return 0, nil
+	}
+	return uint64(len(f.keys)), nil
+}
+
+// Counter return incremented counter
+func (m *MemStorage) Counter(file string, key []byte) (uint64, error) {
+	m.Lock()
+	defer m.Unlock()
+	var counter uint64
+	if f := m.file(file, false); f != nil {
+		if v, ok := f.vals[string(key)]; ok && len(v) == 8 {
+			counter = binary.BigEndian.Uint64(v)
+		}
+	}
+	counter++
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, counter)
+	m.set(file, key, b)
+	return counter, nil
+}
+
+// CloseAll do nothing for memory
+func (m *MemStorage) CloseAll() error {
+	return nil
+}
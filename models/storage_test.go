@@ -0,0 +1,122 @@
+package models_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestMemStorageKeysLikeSlowpoke(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tgram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "keys")
+	disk := models.SlowpokeStorage{}
+	defer disk.CloseAll()
+	mem := models.NewMemStorage()
+
+	for _, s := range []models.Storage{disk, mem} {
+		for _, k := range []string{"a:1", "a:2", "ab:1", "b:1", "b:2", "c"} {
+			if err := s.Set(file, []byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.Delete(file, []byte("b:1"))
+	}
+	cases := []struct {
+		from          string
+		limit, offset uint32
+		asc           bool
+	}{
+		{"", 0, 0, true},
+		{"", 0, 0, false},
+		{"", 2, 1, true},
+		{"a:2", 2, 0, true},
+		{"b:2", 2, 0, false},
+		{"a*", 0, 0, true},
+		{"a*", 1, 0, false},
+		{"b*", 0, 0, false},
+	}
+	for _, c := range cases {
+		var from []byte
+		if c.from != "" {
+			from = []byte(c.from)
+		}
+		want, _ := disk.Keys(file, from, c.limit, c.offset, c.asc)
+		got, _ := mem.Keys(file, from, c.limit, c.offset, c.asc)
+		if fmt.Sprintf("%s", want) != fmt.Sprintf("%s", got) {
+			t.Errorf("Keys(%+v): want %s, got %s", c, want, got)
+		}
+	}
+	wantCnt, _ := disk.Count(file)
+	gotCnt, _ := mem.Count(file)
+	if wantCnt != gotCnt {
+		t.Errorf("Count: want %d, got %d", wantCnt, gotCnt)
+	}
+}
+
+// useMemStorage switch models to empty in-memory storage, call returned func to restore
+func useMemStorage() func() {
+	old := models.GetStorage()
+	models.SetStorage(models.NewMemStorage())
+	return func() { models.SetStorage(old) }
+}
+
+func TestMemStorageArticles(t *testing.T) {
+	defer useMemStorage()()
+
+	a := &models.Article{Lang: "tst", Author: "alice", Title: "first", Body: "first body", Tag: "go"}
+	aid, err := models.ArticleNew(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := models.ArticleGet("tst", "alice", aid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != a.Title || got.Body != a.Body {
+		t.Errorf("want %+v, got %+v", a, got)
+	}
+	articles, _, _, _, _, err := models.AllArticles("tst", "", "go")
+	if err != nil || len(articles) != 1 {
+		t.Fatalf("want one tagged article, got %d (%v)", len(articles), err)
+	}
+	if err = models.ArticleDelete("tst", "alice", aid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = models.ArticleGet("tst", "alice", aid); err == nil {
+		t.Error("article not deleted")
+	}
+	articles, _, _, _, _, _ = models.AllArticles("tst", "", "")
+	if len(articles) != 0 {
+		t.Errorf("want no articles, got %d", len(articles))
+	}
+}
+
+func TestDataRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tgram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	models.SetRoot(dir)
+	defer models.SetRoot(".")
+
+	disk := models.SlowpokeStorage{}
+	if err = disk.Set("db/tst/root", []byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	disk.CloseAll()
+	if _, err = os.Stat(filepath.Join(dir, "db/tst/root")); err != nil {
+		t.Errorf("db not in data root: %v", err)
+	}
+	if got := models.DataPath("/abs/path"); got != "/abs/path" {
+		t.Errorf("want absolute path unchanged, got %s", got)
+	}
+}
@@ -0,0 +1,270 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// tag - count of articles
+	dbTags = "db/%s/tags"
+
+	// TagsMax - max tags of article
+	TagsMax = 5
+	// TagMaxLen - max length of tag
+	TagMaxLen = 20
+	// TagPage - articles per page on tag page
+	TagPage = 20
+
+	// TrendingPeriod - trending tags are counted on articles of this period
+	TrendingPeriod = 7 * 24 * time.Hour
+	// trending is computed on last articles only
+	trendingScan  = 500
+	trendingStore = 10 * time.Minute
+)
+
+// Tag with count of articles
+type Tag struct {
+	Name  string
+	Count int
+}
+
+// TagList return tags of article, old articles have only Tag
+func (a *Article) TagList() []string {
+	if len(a.Tags) == 0 && a.Tag != "" {
+		return []string{a.Tag}
+	}
+	return a.Tags
+}
+
+// SetTags replace tags of article, Tag is kept for old clients
+func (a *Article) SetTags(tags []string) {
+	a.Tags = tags
+	a.Tag = ""
+	if len(tags) > 0 {
+		a.Tag = tags[0]
+	}
+}
+
+// ParseTags split tags by spaces or commas, # is optional
+func ParseTags(s string) (tags []string, err error) {
+	seen := make(map[string]bool)
+	for _, t := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		t = strings.TrimPrefix(t, "#")
+		if t == "" || seen[t] {
+			continue
+		}
+		if len(t) > TagMaxLen {
+			return nil, fmt.Errorf("Tag is too long: %s", t)
+		}
+		for _, r := range t {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+				return nil, fmt.Errorf("Tag must be alphanumeric: %s", t)
+			}
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > TagsMax {
+		return nil, fmt.Errorf("Too many tags, max: %d", TagsMax)
+	}
+	return tags, nil
+}
+
+// tagsSet add article to indexes of new tags and remove from old
+// return changes of counters, apply them with tagsCount when article is unlocked
+func tagsSet(a *Article, oldTags []string) (counts map[string]int) {
+	counts = make(map[string]int)
+	tags := a.TagList()
+	id32 := Uint32toBin(a.ID)
+	for _, t := range oldTags {
+		if !hasTag(tags, t) {
+			db.Delete(fmt.Sprintf(dbATag, a.Lang, t), id32)
+			counts[t]--
+		}
+	}
+	for _, t := range tags {
+		if !hasTag(oldTags, t) {
+			db.Set(fmt.Sprintf(dbATag, a.Lang, t), id32, []byte(a.Author))
+			counts[t]++
+		}
+	}
+	return counts
+}
+
+// tagsCount apply changes of counters of tags
+// counters are locked, so it must not be called under lock of article
+func tagsCount(lang string, counts map[string]int) {
+	for t, delta := range counts {
+		if delta != 0 {
+			tagCount(lang, t, delta)
+		}
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// tagCount change count of articles with tag, tag with 0 articles is removed
+func tagCount(lang, tag string, delta int) {
+	f := fmt.Sprintf(dbTags, lang)
+	unlock := lockKey(f, []byte(tag))
+	defer unlock()
+
+	var cnt uint32
+	if b, err := db.Get(f, []byte(tag)); err == nil && len(b) == 4 {
+		cnt = BintoUint32(b)
+	}
+	if delta < 0 && cnt > 0 {
+		cnt--
+	} else if delta > 0 {
+		cnt++
+	}
+	if cnt == 0 {
+		db.Delete(f, []byte(tag))
+		return
+	}
+	db.Set(f, []byte(tag), Uint32toBin(cnt))
+}
+
+// Tags return all tags sorted by count of articles
+func Tags(lang string) (tags []Tag) {
+	f := fmt.Sprintf(dbTags, lang)
+	keys, _ := db.Keys(f, nil, 0, 0, true)
+	for _, k := range keys {
+		b, err := db.Get(f, k)
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		tags = append(tags, Tag{Name: string(k), Count: int(BintoUint32(b))})
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].Count > tags[j].Count
+	})
+	return tags
+}
+
+// TagArticles return page of articles with tag, newest first, and count of all
+func TagArticles(lang, tag string, page int) (articles []Article, cnt int, err error) {
+	if _, err = ParseTags(tag); err != nil || tag == "" {
+		return articles, 0, errors.New("Tag not found")
+	}
+	fATag := fmt.Sprintf(dbATag, lang, tag)
+	all, _ := db.Count(fATag)
+	cnt = int(all)
+	if page < 0 || page*TagPage >= cnt {
+		return articles, cnt, nil
+	}
+	articles, _, _, err = ArticlesSelect(lang, fATag, nil, TagPage, uint32(page*TagPage), false)
+	return articles, cnt, err
+}
+
+// TrendingTags return most used tags in articles of TrendingPeriod
+func TrendingTags(lang string, cnt int) (tags []Tag) {
+	key := "trending:" + lang
+	if x, found := cc.Get(key); found {
+		tags = x.([]Tag)
+	} else {
+		articles, _, _, _ := ArticlesSelect(lang, fmt.Sprintf(dbAids, lang), nil, trendingScan, 0, false)
+		since := time.Now().Add(-TrendingPeriod)
+		counts := make(map[string]int)
+		for _, a := range articles {
+			if a.CreatedAt.Before(since) {
+				continue
+			}
+			for _, t := range a.TagList() {
+				counts[t]++
+			}
+		}
+		for t, n := range counts {
+			tags = append(tags, Tag{Name: t, Count: n})
+		}
+		sort.Slice(tags, func(i, j int) bool {
+			if tags[i].Count == tags[j].Count {
+				return tags[i].Name < tags[j].Name
+			}
+			return tags[i].Count > tags[j].Count
+		})
+		cc.Set(key, tags, trendingStore)
+	}
+	if len(tags) > cnt {
+		tags = tags[:cnt]
+	}
+	return tags
+}
+
+// TagsRebuild recount articles of all tags of language
+func TagsRebuild(lang string) (tags int, err error) {
+	f := fmt.Sprintf(dbTags, lang)
+	old, _ := db.Keys(f, nil, 0, 0, true)
+	for _, k := range old {
+		db.Delete(f, k)
+	}
+	fAids := fmt.Sprintf(dbAids, lang)
+	keys, err := db.Keys(fAids, nil, 0, 0, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		author, err := db.Get(fAids, key)
+		if err != nil {
+			continue
+		}
+		a, err := ArticleGet(lang, string(author), BintoUint32(key))
+		if err != nil {
+			continue
+		}
+		a.Lang = lang
+		tagsCount(lang, tagsSet(a, nil))
+	}
+	cc.Delete("trending:" + lang)
+	cnt, err := db.Count(f)
+	return int(cnt), err
+}
+
+// TagFollow - tag followed by user with count of articles after last seen
+type TagFollow struct {
+	Name     string
+	LastSeen uint32
+	Unseen   uint32
+}
+
+// FollowTag subscribe user on articles of tag, existing articles are seen
+func FollowTag(lang, tag, username string) (err error) {
+	if tags, err := ParseTags(tag); err != nil || len(tags) != 1 || tags[0] != tag {
+		return errors.New("Tag not found")
+	}
+	if err = Following(lang, "tag", tag, username); err != nil {
+		return err
+	}
+	if ids := keysBefore(fmt.Sprintf(dbATag, lang, tag), 0, 1); len(ids) > 0 {
+		feedSeen(lang, "tag", username, tag, ids[0])
+	}
+	return nil
+}
+
+// IFollowTags return tags followed by user with unseen articles
+func IFollowTags(lang, username string) (tags []TagFollow) {
+	smf := fmt.Sprintf(dbSlaveMaster, lang, "tag")
+	keys, _ := db.Keys(smf, append([]byte(username+":"), '*'), 0, 0, true)
+	for _, k := range keys {
+		t := TagFollow{Name: string(k[len(username)+1:])}
+		if b, err := db.Get(smf, k); err == nil && len(b) == 4 {
+			t.LastSeen = BintoUint32(b)
+		}
+		t.Unseen = uint32(countAfter(fmt.Sprintf(dbATag, lang, t.Name), t.LastSeen))
+		tags = append(tags, t)
+	}
+	return tags
+}
@@ -0,0 +1,88 @@
+package models_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := models.ParseTags("go, #db  go\tweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"go", "db", "web"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("want %v, got %v", want, tags)
+	}
+	for _, s := range []string{"go-lang", "a b c d e f", "abcdefghijklmnopqrstu"} {
+		if _, err := models.ParseTags(s); err == nil {
+			t.Errorf("want error on %q", s)
+		}
+	}
+}
+
+func TestTagIndex(t *testing.T) {
+	defer useMemStorage()()
+
+	counts := func() map[string]int {
+		m := make(map[string]int)
+		for _, tag := range models.Tags("tst") {
+			m[tag.Name] = tag.Count
+		}
+		return m
+	}
+	a1 := &models.Article{Lang: "tst", Author: "alice", Body: "first body", Tags: []string{"go", "db"}}
+	a2 := &models.Article{Lang: "tst", Author: "bob", Body: "second body", Tag: "go"}
+	models.ArticleNew(a1)
+	models.ArticleNew(a2)
+	if want := map[string]int{"go": 2, "db": 1}; !reflect.DeepEqual(counts(), want) {
+		t.Errorf("want %v, got %v", want, counts())
+	}
+	if a1.Tag != "go" || !reflect.DeepEqual(a2.Tags, []string{"go"}) {
+		t.Errorf("want Tag and Tags in sync, got %q %v", a1.Tag, a2.Tags)
+	}
+	if tags := models.TrendingTags("tst", 1); len(tags) != 1 || tags[0].Name != "go" {
+		t.Errorf("want go trending, got %v", tags)
+	}
+
+	models.ArticleModify("tst", "alice", a1.ID, func(a *models.Article) error {
+		a.SetTags([]string{"db", "web"})
+		return nil
+	})
+	if want := map[string]int{"go": 1, "db": 1, "web": 1}; !reflect.DeepEqual(counts(), want) {
+		t.Errorf("want %v, got %v", want, counts())
+	}
+	articles, cnt, err := models.TagArticles("tst", "go", 0)
+	if err != nil || cnt != 1 || len(articles) != 1 || articles[0].ID != a2.ID {
+		t.Errorf("want article of bob, got %v %d %v", articles, cnt, err)
+	}
+
+	models.ArticleDelete("tst", "alice", a1.ID)
+	if want := map[string]int{"go": 1}; !reflect.DeepEqual(counts(), want) {
+		t.Errorf("want %v, got %v", want, counts())
+	}
+	if _, cnt, _ := models.TagArticles("tst", "web", 0); cnt != 0 {
+		t.Errorf("want no articles with web, got %d", cnt)
+	}
+
+	if n, err := models.TagsRebuild("tst"); err != nil || n != 1 {
+		t.Errorf("want 1 tag after rebuild, got %d %v", n, err)
+	}
+}
+
+func TestTagPages(t *testing.T) {
+	defer useMemStorage()()
+
+	for i := 0; i < models.TagPage+5; i++ {
+		models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "some body", Tag: "go"})
+	}
+	first, cnt, _ := models.TagArticles("tst", "go", 0)
+	second, _, _ := models.TagArticles("tst", "go", 1)
+	if cnt != models.TagPage+5 || len(first) != models.TagPage || len(second) != 5 {
+		t.Fatalf("want pages %d and 5 of %d, got %d and %d of %d", models.TagPage, cnt, len(first), len(second), cnt)
+	}
+	if first[0].ID != uint32(cnt) || second[4].ID != 1 {
+		t.Errorf("want newest first, got %d..%d", first[0].ID, second[4].ID)
+	}
+}
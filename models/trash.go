@@ -0,0 +1,220 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// aid - Trashed article of author
+	dbTrash = "db/%s/trash/%s"
+	// deletedAt:lang:aid - author, trash of all languages in order of purge
+	dbTrashQueue = "db/trash"
+)
+
+// TrashRetention - trashed articles are purged after it
+var TrashRetention = 30 * 24 * time.Hour
+
+// Trashed - deleted article, may be restored until purge
+type Trashed struct {
+	Article   Article
+	DeletedAt time.Time
+	DeletedBy string
+}
+
+// PurgeAt return time of permanent delete
+func (t *Trashed) PurgeAt() time.Time {
+	return t.DeletedAt.Add(TrashRetention)
+}
+
+func trashQueueKey(lang string, aid uint32, at time.Time) []byte {
+	key := make([]byte, 8, 8+len(lang)+5)
+	binary.BigEndian.PutUint64(key, uint64(at.Unix()))
+	key = append(key, lang+":"...)
+	return append(key, Uint32toBin(aid)...)
+}
+
+// ArticleTrash move article to trash of author
+// article is removed from lists, tags and search, comments and favorites are kept
+func ArticleTrash(lang, author string, aid uint32, by string) (err error) {
+	counts, err := articleTrash(lang, author, aid, by)
+	tagsCount(lang, counts)
+	rankSync(lang, aid)
+	return err
+}
+
+func articleTrash(lang, author string, aid uint32, by string) (counts map[string]int, err error) {
+	fAUser := fmt.Sprintf(dbAUser, lang, author)
+	unlock := lockKey(fAUser, Uint32toBin(aid))
+	defer unlock()
+	a, err := ArticleGet(lang, author, aid)
+	if err != nil {
+		return nil, errors.New("Article not found")
+	}
+	a.Lang = lang
+	t := Trashed{Article: *a, DeletedAt: time.Now(), DeletedBy: by}
+	t.Article.Comments = nil
+	if err = recordSet(fmt.Sprintf(dbTrash, lang, author), Uint32toBin(aid), schemaArticle, t); err != nil {
+		return nil, err
+	}
+	if err = db.Set(dbTrashQueue, trashQueueKey(lang, aid, t.DeletedAt), []byte(author)); err != nil {
+		return nil, err
+	}
+	if _, err = db.Delete(fAUser, Uint32toBin(aid)); err != nil {
+		return nil, err
+	}
+	oldTags := a.TagList()
+	a.SetTags(nil)
+	counts = tagsSet(a, oldTags)
+	db.Delete(fmt.Sprintf(dbAids, lang), Uint32toBin(aid))
+	SearchRemove(lang, aid)
+	return counts, nil
+}
+
+// TrashGet return trashed article of author
+func TrashGet(lang, author string, aid uint32) (t *Trashed, err error) {
+	if err = recordGet(fmt.Sprintf(dbTrash, lang, author), Uint32toBin(aid), schemaArticle, "Article", &t); err != nil {
+		return nil, errors.New("Article not found in trash")
+	}
+	return t, nil
+}
+
+// Trash return trashed articles of author, last deleted first
+func Trash(lang, author string) (trash []Trashed) {
+	f := fmt.Sprintf(dbTrash, lang, author)
+	keys, _ := db.Keys(f, nil, 0, 0, false)
+	for _, k := range keys {
+		var t Trashed
+		if err := recordGet(f, k, schemaArticle, "Article", &t); err != nil {
+			continue
+		}
+		trash = append(trash, t)
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].DeletedAt.After(trash[j].DeletedAt)
+	})
+	return trash
+}
+
+// ArticleRestore move article from trash back to lists, tags and search
+func ArticleRestore(lang, author string, aid uint32) (a *Article, err error) {
+	a, counts, err := articleRestore(lang, author, aid)
+	tagsCount(lang, counts)
+	rankSync(lang, aid)
+	return a, err
+}
+
+func articleRestore(lang, author string, aid uint32) (a *Article, counts map[string]int, err error) {
+	fAUser := fmt.Sprintf(dbAUser, lang, author)
+	unlock := lockKey(fAUser, Uint32toBin(aid))
+	defer unlock()
+	t, err := TrashGet(lang, author, aid)
+	if err != nil {
+		return nil, nil, err
+	}
+	a = &t.Article
+	a.Lang = lang
+	id32 := Uint32toBin(aid)
+	if err = db.Set(fmt.Sprintf(dbAids, lang), id32, []byte(author)); err != nil {
+		return nil, nil, err
+	}
+	a.SetTags(a.TagList())
+	counts = tagsSet(a, nil)
+	if err = recordSet(fAUser, id32, schemaArticle, a); err != nil {
+		return nil, counts, err
+	}
+	if err = SearchIndex(a); err != nil {
+		return nil, counts, err
+	}
+	db.Delete(dbTrashQueue, trashQueueKey(lang, aid, t.DeletedAt))
+	_, err = db.Delete(fmt.Sprintf(dbTrash, lang, author), id32)
+	return a, counts, err
+}
+
+// ArticlePurge delete article from trash permanently
+func ArticlePurge(lang, author string, aid uint32) (err error) {
+	fAUser := fmt.Sprintf(dbAUser, lang, author)
+	unlock := lockKey(fAUser, Uint32toBin(aid))
+	defer unlock()
+	t, err := TrashGet(lang, author, aid)
+	if err != nil {
+		return err
+	}
+	db.Delete(dbTrashQueue, trashQueueKey(lang, aid, t.DeletedAt))
+	revisionsDelete(lang, aid)
+	articleRefsDelete(lang, aid)
+	_, err = db.Delete(fmt.Sprintf(dbTrash, lang, author), Uint32toBin(aid))
+	return err
+}
+
+// articleRefsDelete remove comments, votes, views, favorites, mutes, mentions,
+// notifications and telegram message of deleted article
+func articleRefsDelete(lang string, aid uint32) {
+	id32 := Uint32toBin(aid)
+
+	// comments and votes
+	fCom := fmt.Sprintf(dbComment, lang)
+	cids := make(map[uint32]bool)
+	keys, _ := db.Keys(fCom, commentsPrefix(aid), 0, 0, true)
+	for _, k := range keys {
+		cids[BintoUint32(k[4:])] = true
+		db.Delete(fCom, k)
+	}
+	fTree := fmt.Sprintf(dbCommentTree, lang)
+	keys, _ = db.Keys(fTree, commentsPrefix(aid), 0, 0, true)
+	for _, k := range keys {
+		db.Delete(fTree, k)
+	}
+	votesDelete(lang, "a", map[uint32]bool{aid: true})
+	votesDelete(lang, "c", cids)
+
+	db.Delete(fmt.Sprintf(dbView, lang), id32)
+
+	// favorites and muted comments: aid:username and username:aid
+	for _, cat := range []string{"fav", "amut"} {
+		ms := fmt.Sprintf(dbMasterSlave, lang, cat)
+		sm := fmt.Sprintf(dbSlaveMaster, lang, cat)
+		keys, _ = db.Keys(ms, append(append(id32, ':'), '*'), 0, 0, true)
+		for _, k := range keys {
+			masterslave, slavemaster := GetMasterSlave(string(id32), string(k[5:]))
+			db.Delete(ms, masterslave)
+			db.Delete(sm, slavemaster)
+		}
+	}
+
+	// mentions in article and comments, notifications about them
+	refsDelete(lang, "m", aid)
+	refsDelete(lang, "n", aid)
+
+	Type2TeleDel(aid)
+}
+
+// TrashPurge delete articles trashed before TrashRetention, return count of purged
+func TrashPurge(now time.Time) (purged int) {
+	before := now.Add(-TrashRetention)
+	keys, _ := db.Keys(dbTrashQueue, nil, 0, 0, true)
+	for _, k := range keys {
+		if len(k) < 8+1+4 || k[len(k)-5] != ':' {
+			db.Delete(dbTrashQueue, k)
+			continue
+		}
+		if time.Unix(int64(binary.BigEndian.Uint64(k[:8])), 0).After(before) {
+			break
+		}
+		author, err := db.Get(dbTrashQueue, k)
+		if err != nil {
+			continue
+		}
+		lang := string(k[8 : len(k)-5])
+		if err = ArticlePurge(lang, string(author), BintoUint32(k[len(k)-4:])); err != nil {
+			// restored or purged already
+			db.Delete(dbTrashQueue, k)
+			continue
+		}
+		purged++
+	}
+	return purged
+}
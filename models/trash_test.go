@@ -0,0 +1,104 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestTrash(t *testing.T) {
+	defer useMemStorage()()
+
+	a := &models.Article{Lang: "tst", Author: "alice", Title: "trashed", Body: "unique words", Tag: "go"}
+	aid, _ := models.ArticleNew(a)
+	if _, err := models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "comment"}, "alice", aid); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.ArticleDelete("tst", "alice", aid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.ArticleGet("tst", "alice", aid); err == nil {
+		t.Error("want article hidden")
+	}
+	if res, _, _ := models.Search("tst", "unique", 0); len(res) != 0 {
+		t.Errorf("want article removed from search, got %v", res)
+	}
+	if _, cnt, _ := models.TagArticles("tst", "go", 0); cnt != 0 {
+		t.Errorf("want article removed from tag, got %d", cnt)
+	}
+	trash := models.Trash("tst", "alice")
+	if len(trash) != 1 || trash[0].Article.ID != aid || trash[0].DeletedBy != "alice" {
+		t.Fatalf("want article in trash, got %v", trash)
+	}
+
+	if _, err := models.ArticleRestore("tst", "alice", aid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.ArticleGet("tst", "alice", aid); err != nil {
+		t.Error("want article restored")
+	}
+	if models.CommentsCount("tst", aid) != 1 {
+		t.Error("want comments kept")
+	}
+	if res, _, _ := models.Search("tst", "unique", 0); len(res) != 1 {
+		t.Errorf("want article searchable, got %v", res)
+	}
+	if _, cnt, _ := models.TagArticles("tst", "go", 0); cnt != 1 {
+		t.Errorf("want article with tag, got %d", cnt)
+	}
+	if len(models.Trash("tst", "alice")) != 0 {
+		t.Error("want empty trash")
+	}
+
+	// purge after retention
+	models.ArticleTrash("tst", "alice", aid, "admin")
+	if n := models.TrashPurge(time.Now()); n != 0 {
+		t.Errorf("want nothing purged before retention, got %d", n)
+	}
+	if n := models.TrashPurge(time.Now().Add(models.TrashRetention + time.Second)); n != 1 {
+		t.Errorf("want 1 purged, got %d", n)
+	}
+	if _, err := models.ArticleRestore("tst", "alice", aid); err == nil {
+		t.Error("want purged article not restorable")
+	}
+}
+
+func TestTrashPurgeRefs(t *testing.T) {
+	defer useMemStorage()()
+
+	for _, u := range []string{"alice", "bob"} {
+		if err := models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Title: "purged", Body: "body"})
+	keep, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Title: "kept", Body: "body"})
+	for _, id := range []uint32{aid, keep} {
+		models.MentionNew("hi @bob", "tst", "hi", "alice", "/@alice/"+fmt.Sprint(id), "/@alice/"+fmt.Sprint(id), id, 0)
+		if _, err := models.ArticleVote("tst", "bob", "alice", id, models.VoteUp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(models.Mentions("tst", "bob")) != 2 {
+		t.Fatal("want mentions")
+	}
+
+	models.ArticleTrash("tst", "alice", aid, "alice")
+	if n := models.TrashPurge(time.Now().Add(models.TrashRetention + time.Second)); n != 1 {
+		t.Fatalf("want 1 purged, got %d", n)
+	}
+	if m := models.Mentions("tst", "bob"); len(m) != 1 || m[0].Aid != keep {
+		t.Errorf("want mention of kept article, got %v", m)
+	}
+	if list, _, _, _ := models.Notifications("tst", "bob", 0); len(list) != 1 || list[0].Aid != keep {
+		t.Errorf("want notification of kept article, got %v", list)
+	}
+	if models.VoteGet("tst", "bob", aid) != 0 || models.VoteGet("tst", "bob", keep) != models.VoteUp {
+		t.Error("want only vote of kept article")
+	}
+	if problems, _ := models.Check("tst", false); len(problems) != 0 {
+		t.Errorf("want consistent db, got %v", problems)
+	}
+}
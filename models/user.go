@@ -11,8 +11,6 @@ import (
 	"strings"
 	"time"
 
-	sp "github.com/recoilme/slowpoke"
-
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,6 +21,17 @@ const (
 	dbMention     = "db/%s/m/%s"
 )
 
+// FollowsPage - users per page of followers and following
+const FollowsPage = 50
+
+// FollowUser - user in list of followers or following with follow state of viewer
+type FollowUser struct {
+	Username   string `json:"username"`
+	Bio        string `json:"bio"`
+	Following  bool   `json:"following"`   // viewer follows user
+	FollowsYou bool   `json:"follows_you"` // user follows viewer
+}
+
 // User model
 type User struct {
 	Username       string `form:"username" json:"username" binding:"exists,alphanum,min=1,max=20"`
@@ -39,6 +48,9 @@ type User struct {
 	NoJs           bool   `json:"-"`
 	Type2Telegram  string `json:"-"`
 	Type2TeleNoTxt bool   `json:"-"`
+	NoCommentMail  bool   `json:"-"` // no email about comments, in-app notifications are kept
+	FollowComments bool   `json:"-"` // notify about comments on articles user commented
+	Created        int64  `json:"-"` // registration time in ns, token of deleted account with same name is refused
 }
 
 type Mention struct {
@@ -56,7 +68,7 @@ func UserNew(user *User) (err error) {
 	f := fmt.Sprintf(dbUser, user.Lang)
 	uname := []byte(user.Username)
 	// check username
-	taken, _ := sp.Has(f, uname)
+	taken, _ := db.Has(f, uname)
 	if taken {
 		return errors.New("Username " + user.Username + " taken")
 	}
@@ -66,17 +78,15 @@ func UserNew(user *User) (err error) {
 	passwordHash, _ := bcrypt.GenerateFromPassword(bytePassword, bcrypt.DefaultCost)
 	user.Password = ""
 	user.PasswordHash = string(passwordHash)
+	user.Created = time.Now().UnixNano()
 
 	// store
-	return sp.SetGob(f, uname, user)
+	return userSet(user)
 }
 
 // UserCheckGet check
 func UserCheckGet(lang, username, password string) (u *User, err error) {
-	f := fmt.Sprintf(dbUser, lang)
-	uname := []byte(username)
-
-	err = sp.GetGob(f, uname, &u)
+	u, err = userGet(lang, []byte(username))
 	if err != nil {
 		return nil, err
 	}
@@ -89,31 +99,38 @@ func UserCheckGet(lang, username, password string) (u *User, err error) {
 
 // UserSave - save
 func UserSave(user *User) (err error) {
-	f := fmt.Sprintf(dbUser, user.Lang)
-	uname := []byte(user.Username)
-	return sp.SetGob(f, uname, user)
+	return userSet(user)
 }
 
 // UserGet return user
 func UserGet(lang, username string) (u *User, err error) {
-	f := fmt.Sprintf(dbUser, lang)
-	uname := []byte(username)
+	return userGet(lang, []byte(username))
+}
 
-	err = sp.GetGob(f, uname, &u)
-	if err != nil {
-		return nil, err
-	}
-	return u, nil
+// UserAuth return true if token of account registered at created is valid,
+// account may be deleted and username registered again
+func UserAuth(lang, username string, created int64) bool {
+	u, err := userGet(lang, []byte(username))
+	return err == nil && u.Created == created
 }
 
-// Following set follow
+// UserExists return true if user is registered
+func UserExists(lang, username string) bool {
+	has, _ := db.Has(fmt.Sprintf(dbUser, lang), []byte(username))
+	return has
+}
+
+// Following set follow, blocked user can't follow
 func Following(lang, cat, u, v string) (err error) {
+	if cat == "fol" && IsBlocked(lang, u, v) {
+		return errors.New("You are blocked by user")
+	}
 	masterslave, slavemaster := GetMasterSlave(u, v)
-	err = sp.Set(fmt.Sprintf(dbMasterSlave, lang, cat), masterslave, nil)
+	err = db.Set(fmt.Sprintf(dbMasterSlave, lang, cat), masterslave, nil)
 	if err != nil {
 		return err
 	}
-	err = sp.Set(fmt.Sprintf(dbSlaveMaster, lang, cat), slavemaster, Uint32toBin(0))
+	err = db.Set(fmt.Sprintf(dbSlaveMaster, lang, cat), slavemaster, Uint32toBin(0))
 	if err != nil {
 		return err
 	}
@@ -123,7 +140,7 @@ func Following(lang, cat, u, v string) (err error) {
 // IsFollowing return IsFollowing
 func IsFollowing(lang, cat, u, v string) bool {
 	_, slavemaster := GetMasterSlave(u, v)
-	has, _ := sp.Has(fmt.Sprintf(dbSlaveMaster, lang, cat), slavemaster)
+	has, _ := db.Has(fmt.Sprintf(dbSlaveMaster, lang, cat), slavemaster)
 	return has
 }
 
@@ -135,7 +152,7 @@ func FollowCount(lang, cat, u string) int {
 	masterstar = append(masterstar, master32...)
 	masterstar = append(masterstar, '*')
 
-	keys, _ := sp.Keys(fmt.Sprintf(dbMasterSlave, lang, cat), masterstar, 0, 0, true)
+	keys, _ := db.Keys(fmt.Sprintf(dbMasterSlave, lang, cat), masterstar, 0, 0, true)
 
 	return len(keys)
 }
@@ -143,11 +160,11 @@ func FollowCount(lang, cat, u string) int {
 // Unfollowing remove follow
 func Unfollowing(lang, cat, u, v string) (err error) {
 	masterslave, slavemaster := GetMasterSlave(u, v)
-	_, err = sp.Delete(fmt.Sprintf(dbMasterSlave, lang, cat), masterslave)
+	_, err = db.Delete(fmt.Sprintf(dbMasterSlave, lang, cat), masterslave)
 	if err != nil {
 		return err
 	}
-	_, err = sp.Delete(fmt.Sprintf(dbSlaveMaster, lang, cat), slavemaster)
+	_, err = db.Delete(fmt.Sprintf(dbSlaveMaster, lang, cat), slavemaster)
 	if err != nil {
 		return err
 	}
@@ -182,7 +199,7 @@ func IFollow(lang, cat, u string) (followings []User) {
 	masterstar = append(masterstar, '*')
 	smf := fmt.Sprintf(dbSlaveMaster, lang, cat)
 
-	keys, _ := sp.Keys(smf, masterstar, 0, 0, true)
+	keys, _ := db.Keys(smf, masterstar, 0, 0, true)
 	//log.Println("keys", keys)
 	lenU := len(u) + 1
 	f := fmt.Sprintf(dbUser, lang)
@@ -191,14 +208,14 @@ func IFollow(lang, cat, u string) (followings []User) {
 		b := k[lenU:]
 		var u User
 
-		e := sp.GetGob(f, b, &u)
+		e := recordGet(f, b, schemaUser, "", (*userRecord)(&u))
 		if e != nil {
 			fmt.Println("GetFollowings", e)
 			continue
 		} else {
 			//log.Println("u:", u)
 			var lastPost uint32
-			b, err := sp.Get(smf, k)
+			b, err := db.Get(smf, k)
 			if err == nil {
 				if len(b) == 4 {
 					lastPost = BintoUint32(b)
@@ -211,7 +228,7 @@ func IFollow(lang, cat, u string) (followings []User) {
 				} else {
 					id32 = Uint32toBin(lastPost)
 				}
-				keys, err := sp.Keys(fAUser, id32, uint32(0), uint32(0), true)
+				keys, err := db.Keys(fAUser, id32, uint32(0), uint32(0), true)
 				//log.Println(fAUser, keys, lastPost)
 				if err == nil {
 					u.Unseen = uint32(len(keys))
@@ -224,6 +241,41 @@ func IFollow(lang, cat, u string) (followings []User) {
 	return followings
 }
 
+// FollowList return page of users following user ("followers") or followed by user ("following"),
+// sorted by username, with follow state of viewer, and count of all
+func FollowList(lang, username, dir, viewer string, page int) (users []FollowUser, cnt int, err error) {
+	if !UserExists(lang, username) {
+		return nil, 0, errors.New("User not found")
+	}
+	f := fmt.Sprintf(dbMasterSlave, lang, "fol")
+	if dir == "following" {
+		f = fmt.Sprintf(dbSlaveMaster, lang, "fol")
+	} else if dir != "followers" {
+		return nil, 0, errors.New("Not implemented")
+	}
+	prefix := append([]byte(username+":"), '*')
+	all, _ := db.Keys(f, prefix, 0, 0, true)
+	cnt = len(all)
+	if page < 0 || page*FollowsPage >= cnt {
+		return users, cnt, nil
+	}
+	keys, _ := db.Keys(f, prefix, FollowsPage, uint32(page*FollowsPage), true)
+	fUser := fmt.Sprintf(dbUser, lang)
+	for _, k := range keys {
+		fu := FollowUser{Username: string(k[len(username)+1:])}
+		var u User
+		if err := recordGet(fUser, []byte(fu.Username), schemaUser, "", (*userRecord)(&u)); err == nil {
+			fu.Bio = u.Bio
+		}
+		if viewer != "" {
+			fu.Following = IsFollowing(lang, "fol", fu.Username, viewer)
+			fu.FollowsYou = IsFollowing(lang, "fol", viewer, fu.Username)
+		}
+		users = append(users, fu)
+	}
+	return users, cnt, nil
+}
+
 // ReplyParse - replace first '@username ' on markdown link and return array of username
 func ReplyParse(s, lang string) string {
 	if len(s) < 2 {
@@ -237,7 +289,7 @@ func ReplyParse(s, lang string) string {
 			//log.Println("uname", firstuname)
 			f := fmt.Sprintf(dbUser, lang)
 			// check username
-			taken, _ := sp.Has(f, []byte(firstuname[1:]))
+			taken, _ := db.Has(f, []byte(firstuname[1:]))
 			if taken {
 				tmp := "[" + firstuname + "](/" + firstuname + ")"
 				// replace res with md
@@ -266,8 +318,8 @@ func MentionNew(s, lang, text, byuser, url, fullurl string, aid, cid uint32) (me
 		uname := element[1:]
 		//fmt.Println("'" + string(uname) + "'")
 		// check username
-		taken, _ := sp.Has(f, []byte(uname))
-		if !taken {
+		taken, _ := db.Has(f, []byte(uname))
+		if !taken || IsBlocked(lang, uname, byuser) {
 			continue
 		}
 		var skip bool
@@ -287,10 +339,21 @@ func MentionNew(s, lang, text, byuser, url, fullurl string, aid, cid uint32) (me
 		mention := Mention{Aid: aid, Cid: cid, Then: time.Now(),
 			ByUsername: byuser, Text: text, Path: fullurl, ToUsername: u}
 		//log.Println(mention)
-		e := sp.SetGob(f, url, mention)
+		e := db.SetGob(f, url, mention)
 		if e != nil {
 			log.Println(e)
 		}
+		if key, e := gobKey(url); e == nil {
+			refAdd(lang, "m", aid, u, key)
+		}
+		// comment starting with username is reply, see ReplyParse
+		kind := NotifyMention
+		if cid != 0 && (strings.HasPrefix(s, "[@"+u+"]") || strings.HasPrefix(s, "@"+u+" ")) {
+			kind = NotifyReply
+		}
+		if e = NotifyNew(lang, u, Notification{Kind: kind, By: byuser, Aid: aid, Cid: cid, Path: fullurl, Text: text}); e != nil {
+			log.Println(e)
+		}
 		mentions = append(mentions, mention)
 	}
 	return mentions
@@ -298,8 +361,13 @@ func MentionNew(s, lang, text, byuser, url, fullurl string, aid, cid uint32) (me
 
 // Mentions return arr of mentions
 func Mentions(lang, username string) (mentions []Mention) {
+	return mentionsSelect(lang, username, 10)
+}
+
+// mentionsSelect return limit (0 - all) mentions, newest first
+func mentionsSelect(lang, username string, limit uint32) (mentions []Mention) {
 	f := fmt.Sprintf(dbMention, lang, username)
-	keys, err := sp.Keys(f, nil, uint32(10), uint32(0), false)
+	keys, err := db.Keys(f, nil, limit, uint32(0), false)
 	if err != nil {
 		//log.Println(err)
 		return mentions
@@ -307,7 +375,7 @@ func Mentions(lang, username string) (mentions []Mention) {
 	for _, k := range keys {
 		//log.Println(k, string(k))
 		var mention Mention
-		err := sp.GetGob(f, k, &mention)
+		err := db.GetGob(f, k, &mention)
 		if err == nil {
 			//log.Println(mention)
 			mentions = append(mentions, mention)
@@ -327,13 +395,12 @@ func Mentions(lang, username string) (mentions []Mention) {
 // MentionDel remove mention for username by path
 func MentionDel(lang, username, path string) {
 	//log.Println("MentionDel:", lang, username, "."+path+".")
-	f := fmt.Sprintf(dbMention, lang, username)
 	bufKey := bytes.Buffer{}
 	err := gob.NewEncoder(&bufKey).Encode(path)
 	if err == nil {
-		//ex, e := sp.Has(f, bufKey.Bytes())
+		//ex, e := db.Has(f, bufKey.Bytes())
 		//log.Println(ex, e, f, bufKey.Bytes())
-		sp.Delete(f, bufKey.Bytes())
+		refRemove(lang, "m", username, bufKey.Bytes())
 	}
 }
 
@@ -345,7 +412,7 @@ func SendMentions(lang, SMTPHost, SMTPPort, SMTPUser, SMTPPassword, Domain strin
 	for _, m := range mentions {
 		uname := m.ToUsername
 		var u User
-		err := sp.GetGob(f, []byte(uname), &u)
+		err := recordGet(f, []byte(uname), schemaUser, "", (*userRecord)(&u))
 		if err != nil {
 			continue
 		}
@@ -0,0 +1,45 @@
+package models_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestFollowList(t *testing.T) {
+	defer useMemStorage()()
+	models.UserNew(&models.User{Lang: "tst", Username: "alice", Password: "password"})
+	for i := 0; i < models.FollowsPage+5; i++ {
+		u := fmt.Sprintf("u%03d", i)
+		if i < 4 {
+			models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password", Bio: "bio " + u})
+		}
+		models.Following("tst", "fol", "alice", u)
+	}
+	// alice follows u001, u001 follows u002, u003 follows u001
+	models.Following("tst", "fol", "u001", "alice")
+	models.Following("tst", "fol", "u002", "u001")
+	models.Following("tst", "fol", "u001", "u003")
+
+	users, cnt, err := models.FollowList("tst", "alice", "followers", "u001", 0)
+	if err != nil || cnt != models.FollowsPage+5 || len(users) != models.FollowsPage {
+		t.Fatalf("want first page of followers, got %d %d %v", cnt, len(users), err)
+	}
+	if users[1].Username != "u001" || users[1].Bio != "bio u001" || users[1].FollowsYou || users[1].Following {
+		t.Errorf("want viewer itself, got %+v", users[1])
+	}
+	if !users[2].Following || users[2].FollowsYou || users[3].Following || !users[3].FollowsYou {
+		t.Errorf("want follow state of viewer, got %+v %+v", users[2], users[3])
+	}
+	if users, _, _ = models.FollowList("tst", "alice", "followers", "", 1); len(users) != 5 {
+		t.Errorf("want second page, got %d", len(users))
+	}
+	users, cnt, _ = models.FollowList("tst", "alice", "following", "u002", 0)
+	if cnt != 1 || users[0].Username != "u001" || !users[0].FollowsYou {
+		t.Errorf("want following, got %+v", users)
+	}
+	if _, _, err = models.FollowList("tst", "nobody", "followers", "", 0); err == nil {
+		t.Error("want user not found")
+	}
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Profile - public and private fields of user for export
+type Profile struct {
+	Username       string `json:"username"`
+	Email          string `json:"email"`
+	Bio            string `json:"bio"`
+	Image          string `json:"image"`
+	NoJs           bool   `json:"nojs"`
+	Type2Telegram  string `json:"type2telegram"`
+	NoCommentMail  bool   `json:"nocommentmail"`
+	FollowComments bool   `json:"followcomments"`
+}
+
+// UserComment - comment of user with article it belongs to
+type UserComment struct {
+	Aid     uint32    `json:"aid"`
+	Author  string    `json:"author"` // author of article
+	ID      uint32    `json:"id"`
+	Body    string    `json:"body"`
+	Plus    uint32    `json:"plus"`
+	Created time.Time `json:"created"`
+}
+
+// UserFavorite - favorite article of user
+type UserFavorite struct {
+	Aid    uint32 `json:"aid"`
+	Author string `json:"author"`
+	Title  string `json:"title"`
+}
+
+// UserImage - uploaded original image of user
+type UserImage struct {
+	Name string `json:"name"`
+	Path string `json:"path"` // path under data root, url is host + "i/" + Path[4:]
+}
+
+// UserData - personal data of user, see UserExport
+type UserData struct {
+	Profile       Profile        `json:"profile"`
+	Articles      []Article      `json:"articles"`
+	Drafts        []Draft        `json:"drafts"`
+	Trash         []Trashed      `json:"trash"`
+	Comments      []UserComment  `json:"comments"`
+	Follows       []string       `json:"follows"`
+	Tags          []string       `json:"tags"` // followed tags
+	Blocked       []string       `json:"blocked"`
+	Muted         []string       `json:"muted"`
+	Favorites     []UserFavorite `json:"favorites"`
+	Mentions      []Mention      `json:"mentions"`
+	Notifications []Notification `json:"notifications"`
+	Images        []UserImage    `json:"images"`
+}
+
+// UserExport collect personal data of user: profile, articles, comments, follows,
+// favorites, mentions, notifications and uploaded original images
+func UserExport(lang, username string) (d *UserData, err error) {
+	u, err := UserGet(lang, username)
+	if err != nil {
+		return nil, errors.New("User not found")
+	}
+	d = &UserData{Profile: Profile{Username: u.Username, Email: u.Email, Bio: u.Bio, Image: u.Image,
+		NoJs: u.NoJs, Type2Telegram: u.Type2Telegram, NoCommentMail: u.NoCommentMail, FollowComments: u.FollowComments},
+		Articles: []Article{}, Comments: []UserComment{}, Follows: []string{}, Tags: []string{},
+		Favorites: []UserFavorite{}, Mentions: []Mention{}, Notifications: []Notification{}, Images: []UserImage{}}
+
+	from := ""
+	for {
+		articles, _, _, _, _, err := ArticlesAuthor(lang, "", username, from)
+		if err != nil {
+			return nil, err
+		}
+		d.Articles = append(d.Articles, articles...)
+		if len(articles) < 20 {
+			break
+		}
+		from = fmt.Sprint(articles[len(articles)-1].ID)
+	}
+	d.Drafts = append([]Draft{}, Drafts(lang, username)...)
+	d.Trash = append([]Trashed{}, Trash(lang, username)...)
+	d.Comments = append(d.Comments, userComments(lang, username)...)
+	for _, f := range IFollow(lang, "fol", username) {
+		d.Follows = append(d.Follows, f.Username)
+	}
+	for _, t := range IFollowTags(lang, username) {
+		d.Tags = append(d.Tags, t.Name)
+	}
+	for _, a := range favoritesSelect(lang, username, 0) {
+		d.Favorites = append(d.Favorites, UserFavorite{Aid: a.ID, Author: a.Author, Title: a.Title})
+	}
+	d.Mentions = append(d.Mentions, mentionsSelect(lang, username, 0)...)
+	for page := 0; ; page++ {
+		list, _, _, err := Notifications(lang, username, page)
+		if err != nil {
+			return nil, err
+		}
+		d.Notifications = append(d.Notifications, list...)
+		if len(list) < NotifyPage {
+			break
+		}
+	}
+	d.Blocked = append([]string{}, Blocked(lang, "blk", username)...)
+	d.Muted = append([]string{}, Blocked(lang, "mut", username)...)
+
+	dir := DataPath(filepath.Join("img", lang, username))
+	files, _ := filepath.Glob(filepath.Join(dir, "*_.png"))
+	ids := make(map[string]int)
+	for _, f := range files {
+		m := imgName.FindStringSubmatch(filepath.Base(f))
+		if m == nil {
+			continue
+		}
+		name := m[1] + ".png"
+		ids[name], _ = strconv.Atoi(m[1])
+		d.Images = append(d.Images, UserImage{Name: name, Path: fmt.Sprintf(fileImg, lang, username, ids[name], "_.png")})
+	}
+	sort.Slice(d.Images, func(i, j int) bool { return ids[d.Images[i].Name] < ids[d.Images[j].Name] })
+	return d, nil
+}
+
+// userComments return comments of user on all articles
+func userComments(lang, username string) (comments []UserComment) {
+	fCom := fmt.Sprintf(dbComment, lang)
+	fAids := fmt.Sprintf(dbAids, lang)
+	keys, _ := db.Keys(fCom, nil, 0, 0, true)
+	for _, k := range keys {
+		var c Article
+		if len(k) != 8 || recordGet(fCom, k, schemaArticle, "", &c) != nil || c.Author != username {
+			continue
+		}
+		uc := UserComment{Aid: BintoUint32(k[:4]), ID: c.ID, Body: c.Body, Plus: c.Plus, Created: c.CreatedAt}
+		if author, err := db.Get(fAids, k[:4]); err == nil {
+			uc.Author = string(author)
+		}
+		comments = append(comments, uc)
+	}
+	return comments
+}
@@ -0,0 +1,58 @@
+package models_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestUserExport(t *testing.T) {
+	defer useMemStorage()()
+	dir, err := ioutil.TempDir("", "tgram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	models.SetRoot(dir)
+	defer models.SetRoot(".")
+
+	for _, u := range []string{"alice", "bob"} {
+		models.UserNew(&models.User{Lang: "tst", Username: u, Password: "password", Email: u + "@example.com"})
+	}
+	for i := 0; i < 25; i++ {
+		models.ArticleNew(&models.Article{Lang: "tst", Author: "bob", Body: "bob article"})
+	}
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Title: "Hi", Body: "alice article"})
+	models.CommentNew(&models.Article{Lang: "tst", Author: "bob", Body: "@alice comment"}, "alice", aid)
+	models.MentionNew("@alice comment", "tst", "comment", "bob", "/@alice/26", "/@alice/26#comment1", aid, 1)
+	models.Following("tst", "fol", "alice", "bob")
+	models.Following("tst", "fav", string(models.Uint32toBin(aid)), "bob")
+	for _, name := range []string{"2_.png", "2.png", "10_.png"} {
+		img := filepath.Join(dir, "img", "tst", "bob", name)
+		os.MkdirAll(filepath.Dir(img), 0755)
+		ioutil.WriteFile(img, []byte("png"), 0644)
+	}
+
+	d, err := models.UserExport("tst", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Profile.Email != "bob@example.com" || len(d.Articles) != 25 {
+		t.Errorf("want profile and all articles, got %+v %d", d.Profile, len(d.Articles))
+	}
+	if len(d.Comments) != 1 || d.Comments[0].Author != "alice" || d.Comments[0].Aid != aid {
+		t.Errorf("want comment with article, got %+v", d.Comments)
+	}
+	if len(d.Follows) != 1 || d.Follows[0] != "alice" || len(d.Favorites) != 1 || d.Favorites[0].Title != "Hi" {
+		t.Errorf("want follows and favorites, got %v %v", d.Follows, d.Favorites)
+	}
+	if len(d.Images) != 2 || d.Images[0].Name != "2.png" || d.Images[1].Path != "img/tst/bob/10_.png" {
+		t.Errorf("want original images, got %+v", d.Images)
+	}
+	if d, _ = models.UserExport("tst", "alice"); len(d.Mentions) != 1 {
+		t.Errorf("want mentions, got %+v", d.Mentions)
+	}
+}
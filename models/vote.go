@@ -0,0 +1,183 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// ledger of votes, cat: "a" - articles, "c" - comments
+	// username:aid or username:cid - direction
+	dbVote = "db/%s/%svote"
+	// time+id+username - direction of recent votes, older than RankRising are pruned
+	dbVoteLog = "db/%s/%svlog"
+)
+
+// Vote directions
+const (
+	VoteDown    = -1
+	VoteRetract = 0
+	VoteUp      = 1
+)
+
+func voteKey(username string, id uint32) []byte {
+	return append([]byte(username+":"), Uint32toBin(id)...)
+}
+
+func voteGet(lang, cat, username string, id uint32) int {
+	b, err := db.Get(fmt.Sprintf(dbVote, lang, cat), voteKey(username, id))
+	if err != nil || len(b) != 1 {
+		return VoteRetract
+	}
+	switch b[0] {
+	case '+':
+		return VoteUp
+	case '-':
+		return VoteDown
+	}
+	return VoteRetract
+}
+
+func voteLogKey(at int64, username string, id uint32) []byte {
+	b := make([]byte, 8, 12+len(username))
+	binary.BigEndian.PutUint64(b, uint64(at))
+	return append(append(b, Uint32toBin(id)...), username...)
+}
+
+// voteAt return time of vote in ns from index of votes, 0 if unknown
+func voteAt(lang, cat, username string, id uint32) int64 {
+	b, err := db.Get(fmt.Sprintf(dbVoteIDs, lang, cat), voteIDKey(username, id))
+	if err != nil || len(b) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func voteSet(lang, cat, username string, id uint32, dir int) error {
+	return voteSetAt(lang, cat, username, id, dir, time.Now().UnixNano())
+}
+
+// voteSetAt store vote cast at time in ns, time 0 is unknown and vote is not logged
+func voteSetAt(lang, cat, username string, id uint32, dir int, at int64) (err error) {
+	f := fmt.Sprintf(dbVote, lang, cat)
+	fIDs := fmt.Sprintf(dbVoteIDs, lang, cat)
+	fLog := fmt.Sprintf(dbVoteLog, lang, cat)
+	if old := voteAt(lang, cat, username, id); old != 0 {
+		db.Delete(fLog, voteLogKey(old, username, id))
+	}
+	switch dir {
+	case VoteUp, VoteDown:
+		sign := []byte(map[int]string{VoteUp: "+", VoteDown: "-"}[dir])
+		var t []byte
+		if at != 0 {
+			t = make([]byte, 8)
+			binary.BigEndian.PutUint64(t, uint64(at))
+			if err = db.Set(fLog, voteLogKey(at, username, id), sign); err != nil {
+				return err
+			}
+		}
+		if err = db.Set(fIDs, voteIDKey(username, id), t); err != nil {
+			return err
+		}
+		return db.Set(f, voteKey(username, id), sign)
+	}
+	db.Delete(fIDs, voteIDKey(username, id))
+	_, err = db.Delete(f, voteKey(username, id))
+	return err
+}
+
+// VoteGet return direction of user vote on article
+func VoteGet(lang, username string, aid uint32) int {
+	return voteGet(lang, "a", username, aid)
+}
+
+// ArticleVote set, flip or retract (dir 0) user vote on article
+// counters of article and ledger are updated together
+func ArticleVote(lang, username, author string, aid uint32, dir int) (a *Article, err error) {
+	if dir < VoteDown || dir > VoteUp {
+		return nil, errors.New("Not implemented")
+	}
+	return ArticleModify(lang, author, aid, func(a *Article) error {
+		old := VoteGet(lang, username, aid)
+		if old == dir {
+			if dir == VoteRetract {
+				return errors.New("You have not voted yet")
+			}
+			return errors.New("You have already voted")
+		}
+		// undo old vote
+		switch old {
+		case VoteUp:
+			if a.Plus > 0 {
+				a.Plus--
+			}
+		case VoteDown:
+			if a.Minus > 0 {
+				a.Minus--
+			}
+		}
+		switch dir {
+		case VoteUp:
+			a.Plus++
+		case VoteDown:
+			a.Minus++
+		}
+		return voteSet(lang, "a", username, aid, dir)
+	})
+}
+
+// CommentVoteGet return true if user voted for comment
+func CommentVoteGet(lang, username string, cid uint32) bool {
+	return voteGet(lang, "c", username, cid) == VoteUp
+}
+
+// CommentVotes return comments voted by user
+func CommentVotes(lang, username string, comments []Article) map[uint32]bool {
+	votes := make(map[uint32]bool)
+	if username == "" {
+		return votes
+	}
+	for _, c := range comments {
+		if CommentVoteGet(lang, username, c.ID) {
+			votes[c.ID] = true
+		}
+	}
+	return votes
+}
+
+// CommentVote set or retract user vote on comment, comments are positive only
+func CommentVote(lang, username string, aid, cid uint32, up bool) (c *Article, err error) {
+	return CommentModify(lang, aid, cid, func(c *Article) error {
+		if up && c.Deleted {
+			return errors.New("Comment deleted")
+		}
+		voted := CommentVoteGet(lang, username, cid)
+		if voted == up {
+			if up {
+				return errors.New("Oh: only one vote for each comment allowed(")
+			}
+			return errors.New("You have not voted yet")
+		}
+		dir := VoteRetract
+		if up {
+			dir = VoteUp
+			c.Plus++
+		} else if c.Plus > 0 {
+			c.Plus--
+		}
+		return voteSet(lang, "c", username, cid, dir)
+	})
+}
+
+// votesDelete remove votes of all users on ids from ledger
+func votesDelete(lang, cat string, ids map[uint32]bool) {
+	fIDs := fmt.Sprintf(dbVoteIDs, lang, cat)
+	for id := range ids {
+		keys, _ := db.Keys(fIDs, append(Uint32toBin(id), '*'), 0, 0, true)
+		for _, k := range keys {
+			voteSet(lang, cat, string(k[4:]), id, VoteRetract)
+		}
+	}
+}
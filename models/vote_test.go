@@ -0,0 +1,71 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/recoilme/tgram/models"
+)
+
+func TestArticleVoteLedger(t *testing.T) {
+	defer useMemStorage()()
+
+	aid, err := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "article body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(plus, minus uint32, vote int) {
+		t.Helper()
+		a, _ := models.ArticleGet("tst", "alice", aid)
+		if a.Plus != plus || a.Minus != minus {
+			t.Errorf("want %d:%d, got %d:%d", plus, minus, a.Plus, a.Minus)
+		}
+		if v := models.VoteGet("tst", "bob", aid); v != vote {
+			t.Errorf("want vote %d, got %d", vote, v)
+		}
+	}
+	if _, err = models.ArticleVote("tst", "bob", "alice", aid, models.VoteUp); err != nil {
+		t.Fatal(err)
+	}
+	check(1, 0, models.VoteUp)
+	if _, err = models.ArticleVote("tst", "bob", "alice", aid, models.VoteUp); err == nil {
+		t.Error("second vote in same direction accepted")
+	}
+	check(1, 0, models.VoteUp)
+	// flip
+	if _, err = models.ArticleVote("tst", "bob", "alice", aid, models.VoteDown); err != nil {
+		t.Fatal(err)
+	}
+	check(0, 1, models.VoteDown)
+	// retract
+	if _, err = models.ArticleVote("tst", "bob", "alice", aid, models.VoteRetract); err != nil {
+		t.Fatal(err)
+	}
+	check(0, 0, models.VoteRetract)
+	if _, err = models.ArticleVote("tst", "bob", "alice", aid, models.VoteRetract); err == nil {
+		t.Error("retract without vote accepted")
+	}
+}
+
+func TestCommentVoteLedger(t *testing.T) {
+	defer useMemStorage()()
+
+	aid, _ := models.ArticleNew(&models.Article{Lang: "tst", Author: "alice", Body: "article body"})
+	cid, err := models.CommentNew(&models.Article{Lang: "tst", Author: "alice", Body: "comment"}, "alice", aid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = models.CommentVote("tst", "bob", aid, cid, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = models.CommentVote("tst", "bob", aid, cid, true); err == nil {
+		t.Error("second comment vote accepted")
+	}
+	comments, _, _ := models.Comments("tst", aid, 0)
+	if votes := models.CommentVotes("tst", "bob", comments); !votes[cid] {
+		t.Error("vote not in ledger")
+	}
+	c, err := models.CommentVote("tst", "bob", aid, cid, false)
+	if err != nil || c.Plus != 0 || models.CommentVoteGet("tst", "bob", cid) {
+		t.Errorf("want retracted vote, got %+v (%v)", c, err)
+	}
+}
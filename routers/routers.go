@@ -1,12 +1,15 @@
 package routers
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"image/png"
 	"io/ioutil"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"strconv"
@@ -61,6 +64,7 @@ func CheckAuth() gin.HandlerFunc {
 		found := false
 		acceptedLang := []string{"de", "en", "es", "fr", "ko", "pt", "ru", "sv", "tr", "us", "zh", "tst", "sub", "bs", "ph", "id"}
 		var tokenStr, username, image, nojs string
+		var created int64
 		c.Set("nojs", nojs)
 
 		hosts := strings.Split(c.Request.Host, ".")
@@ -161,10 +165,18 @@ func CheckAuth() gin.HandlerFunc {
 					if claims["nojs"] != nil {
 						nojs = claims["nojs"].(string)
 					}
+					// tokens issued before registration time was kept have no created
+					if s, ok := claims["created"].(string); ok {
+						created, _ = strconv.ParseInt(s, 10, 64)
+					}
 
 				}
 			}
 		}
+		if username != "" && !models.UserAuth(host, username, created) {
+			// deleted account or another account with same name
+			username, image = "", ""
+		}
 		c.Set("token", tokenStr)
 		c.Set("username", username)
 		c.Set("image", image)
@@ -235,12 +247,8 @@ func Main(c *gin.Context) {
 	} else {
 		models.DauSet(c.GetString("lang"), username)
 		users := models.IFollow(c.GetString("lang"), "fol", username)
-		mentions := models.Mentions(c.GetString("lang"), username)
 
-		personal := false
-		if len(mentions) > 0 {
-			personal = true
-		}
+		personal := models.NotifyUnread(c.GetString("lang"), username) > 0
 		for _, u := range users {
 			if u.Unseen > 0 {
 				personal = true
@@ -249,15 +257,7 @@ func Main(c *gin.Context) {
 		}
 
 		if personal {
-			c.Set("lang", c.GetString("lang"))
-			c.Set("users", users)
-			c.Set("mentions", mentions)
-			if len(users) > 0 || len(mentions) > 0 {
-				c.Set("personal", true)
-			}
-			c.Set("dau", models.DauGet(c.GetString("lang")))
-			c.Set("wau", models.WauGet(c.GetString("lang")))
-			c.HTML(http.StatusOK, "home.html", c.Keys)
+			renderHome(c)
 			return
 		}
 		c.Redirect(http.StatusFound, "/mid")
@@ -273,15 +273,27 @@ func Home(c *gin.Context) {
 func renderHome(c *gin.Context) {
 	username := c.GetString("username")
 	var users []models.User
-	var mentions []models.Mention
+	var tags []models.TagFollow
+	var notifications []models.Notification
+	var unread int
 	if username != "" {
 		users = models.IFollow(c.GetString("lang"), "fol", username)
-		mentions = models.Mentions(c.GetString("lang"), username)
+		tags = models.IFollowTags(c.GetString("lang"), username)
+		// last unread notifications
+		var list []models.Notification
+		list, _, unread, _ = models.Notifications(c.GetString("lang"), username, 0)
+		for _, n := range list {
+			if !n.Read && len(notifications) < 10 {
+				notifications = append(notifications, n)
+			}
+		}
 	}
 	c.Set("lang", c.GetString("lang"))
 	c.Set("users", users)
-	c.Set("mentions", mentions)
-	if len(users) > 0 || len(mentions) > 0 {
+	c.Set("tags", tags)
+	c.Set("notifications", notifications)
+	c.Set("unread", unread)
+	if len(users) > 0 || len(tags) > 0 || unread > 0 {
 		c.Set("personal", true)
 	}
 	c.Set("dau", models.DauGet(c.GetString("lang")))
@@ -298,6 +310,7 @@ func All(c *gin.Context) {
 		renderErr(c, err)
 		return
 	}
+	articles = models.Unmuted(articles, models.Muted(c.GetString("lang"), c.GetString("username")))
 	//log.Println(len(articles))
 	c.Set("articles", articles)
 	if c.Query("tag") == "" {
@@ -324,24 +337,220 @@ func All(c *gin.Context) {
 	}
 }
 
-func Top(c *gin.Context) {
-	articles, err := models.TopArticles(c.GetString("lang"), uint32(20), "plus")
+// Notifications - inbox of user, ?p= for page, POST marks all read
+// curl --header "Content-type:application/json" 'http://sub.localhost:8081/notifications'
+func Notifications(c *gin.Context) {
+	lang, username := c.GetString("lang"), c.GetString("username")
+	switch c.Request.Method {
+	case "GET":
+		page, _ := strconv.Atoi(c.Query("p"))
+		list, cnt, unread, err := models.Notifications(lang, username, page)
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		if list == nil {
+			list = []models.Notification{}
+		}
+		switch c.Request.Header.Get("Content-type") {
+		case "application/json":
+			c.JSON(http.StatusOK, gin.H{"count": cnt, "unread": unread, "page": page, "notifications": list})
+		default:
+			c.Set("notifications", list)
+			c.Set("cnt", cnt)
+			c.Set("unread", unread)
+			c.Set("p", page)
+			if page > 0 {
+				c.Set("prev", page-1)
+			}
+			if (page+1)*models.NotifyPage < cnt {
+				c.Set("next", page+1)
+			}
+			c.HTML(http.StatusOK, "notifications.html", c.Keys)
+		}
+	case "POST":
+		if c.Request.Header.Get("Content-type") != "application/json" && c.GetString("token") != c.PostForm("token") {
+			renderErr(c, errors.New("Invalid token("))
+			return
+		}
+		marked, err := models.NotifyRead(lang, username, 0)
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		switch c.Request.Header.Get("Content-type") {
+		case "application/json":
+			c.JSON(http.StatusOK, gin.H{"marked": marked})
+		default:
+			c.Redirect(http.StatusFound, "/notifications")
+		}
+	}
+}
+
+// Feed - articles of followed authors, newest first, ?before=aid for next page
+// curl --header "Content-type:application/json" 'http://sub.localhost:8081/feed'
+func Feed(c *gin.Context) {
+	before, _ := strconv.Atoi(c.Query("before"))
+	articles, next, err := models.Feed(c.GetString("lang"), c.GetString("username"), uint32(before))
 	if err != nil {
 		renderErr(c, err)
 		return
 	}
-	c.Set("articles", articles)
-	c.HTML(http.StatusOK, "all.html", c.Keys)
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		var newa = make([]models.Article, 0, 0)
+		for _, a := range articles {
+			a.HTML = ""
+			a.Body = GetLead(a.Body)
+			newa = append(newa, a)
+		}
+		c.JSON(http.StatusOK, gin.H{"articles": newa, "next": next})
+	default:
+		c.Set("articles", articles)
+		c.Set("before", before)
+		c.Set("next", next)
+		c.HTML(http.StatusOK, "feed.html", c.Keys)
+	}
+}
+
+// Top - best articles, ?w=day|week|month|all for period,
+// ?by=controversial|rising|discussed|read for other ranking
+func Top(c *gin.Context) {
+	rank(c, "plus")
 }
 
+// Btm - worst articles, ?w and ?by as for top
 func Btm(c *gin.Context) {
-	articles, err := models.TopArticles(c.GetString("lang"), uint32(20), "minus")
+	rank(c, "minus")
+}
+
+// rank render page of ranked articles
+func rank(c *gin.Context, by string) {
+	if c.Query("by") != "" {
+		by = c.Query("by")
+	}
+	w := c.Query("w")
+	page, _ := strconv.Atoi(c.Query("p"))
+	articles, cnt, err := models.Rank(c.GetString("lang"), by, w, page)
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	articles = models.Unmuted(articles, models.Muted(c.GetString("lang"), c.GetString("username")))
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		var newa = make([]models.Article, 0, 0)
+		for _, a := range articles {
+			a.HTML = ""
+			a.Body = GetLead(a.Body)
+			newa = append(newa, a)
+		}
+		c.JSON(http.StatusOK, gin.H{"by": by, "w": w, "count": cnt, "page": page, "articles": newa})
+	default:
+		c.Set("articles", articles)
+		c.Set("by", by)
+		c.Set("scorers", models.RankScorers())
+		c.Set("w", w)
+		c.Set("windows", []string{"", "day", "week", "month", "all"})
+		c.Set("cnt", cnt)
+		c.Set("p", page)
+		if page > 0 {
+			c.Set("prev", page-1)
+		}
+		if (page+1)*models.RankPage < cnt {
+			c.Set("next", page+1)
+		}
+		c.HTML(http.StatusOK, "top.html", c.Keys)
+	}
+}
+
+// Tags - directory of tags with count of articles and trending tags
+func Tags(c *gin.Context) {
+	lang := c.GetString("lang")
+	tags := models.Tags(lang)
+	trending := models.TrendingTags(lang, 20)
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		c.JSON(http.StatusOK, gin.H{"tags": tags, "trending": trending})
+	default:
+		c.Set("tags", tags)
+		c.Set("trending", trending)
+		c.HTML(http.StatusOK, "tags.html", c.Keys)
+	}
+}
+
+// Tag - page of articles with tag
+func Tag(c *gin.Context) {
+	tag := c.Param("name")
+	page, _ := strconv.Atoi(c.Query("p"))
+	articles, cnt, err := models.TagArticles(c.GetString("lang"), tag, page)
 	if err != nil {
 		renderErr(c, err)
 		return
 	}
+	articles = models.Unmuted(articles, models.Muted(c.GetString("lang"), c.GetString("username")))
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		var newa = make([]models.Article, 0, 0)
+		for _, a := range articles {
+			a.HTML = ""
+			a.Body = GetLead(a.Body)
+			newa = append(newa, a)
+		}
+		c.JSON(http.StatusOK, gin.H{"tag": tag, "count": cnt, "page": page, "articles": newa})
+	default:
+		c.Set("tag", tag)
+		c.Set("following", models.IsFollowing(c.GetString("lang"), "tag", tag, c.GetString("username")))
+		c.Set("cnt", cnt)
+		c.Set("articles", articles)
+		c.Set("p", page)
+		if page > 0 {
+			c.Set("prev", page-1)
+		}
+		if (page+1)*models.TagPage < cnt {
+			c.Set("next", page+1)
+		}
+		c.HTML(http.StatusOK, "tag.html", c.Keys)
+	}
+}
+
+// Search - ranked full text search in articles of language
+func Search(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	page, _ := strconv.Atoi(c.Query("p"))
+	c.Set("q", q)
+	var articles []models.Article
+	if q != "" {
+		var cnt int
+		var err error
+		articles, cnt, err = models.Search(c.GetString("lang"), q, page)
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		c.Set("cnt", cnt)
+		c.Set("p", page)
+		if page > 0 {
+			c.Set("prev", page-1)
+		}
+		if (page+1)*models.SearchPage < cnt {
+			c.Set("next", page+1)
+		}
+	}
 	c.Set("articles", articles)
-	c.HTML(http.StatusOK, "all.html", c.Keys)
+
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		var newa = make([]models.Article, 0, 0)
+		for _, a := range articles {
+			a.HTML = ""
+			a.Body = GetLead(a.Body)
+			newa = append(newa, a)
+		}
+		c.JSON(http.StatusOK, gin.H{"q": q, "count": c.GetInt("cnt"), "page": page, "articles": newa})
+	default:
+		c.HTML(http.StatusOK, "search.html", c.Keys)
+	}
 }
 
 func renderErr(c *gin.Context, err error) {
@@ -357,11 +566,12 @@ func renderErr(c *gin.Context, err error) {
 	}
 }
 
-func genToken(username, image, nojs string) (string, error) {
+func genToken(username, image, nojs string, created int64) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"username": username,
 		"image":    image,
 		"nojs":     nojs,
+		"created":  strconv.FormatInt(created, 10),
 	})
 
 	// Sign and get the complete encoded token as a string using the secret
@@ -376,6 +586,10 @@ func Register(c *gin.Context) {
 	case "POST":
 		ip := c.ClientIP()
 
+		if ban := models.IPBanGet(c.GetString("lang"), ip); ban != nil {
+			renderErr(c, ban)
+			return
+		}
 		wait := models.RegisterIPGet(ip) //ratelimit(ip, RateIP)
 		if wait > 0 {
 			e := fmt.Sprintf("Rate limit on registration from your ip, please wait: %d Seconds", wait)
@@ -422,7 +636,7 @@ func Register(c *gin.Context) {
 			return
 		}
 
-		tokenString, err := genToken(u.Username, "", "")
+		tokenString, err := genToken(u.Username, "", "", u.Created)
 		if err != nil {
 			renderErr(c, err)
 			return
@@ -456,19 +670,26 @@ func Settings(c *gin.Context) {
 		c.Set("bio", user.Bio)
 		c.Set("email", user.Email)
 		c.Set("image", user.Image)
+		c.Set("blocked", models.Blocked(c.GetString("lang"), "blk", user.Username))
+		c.Set("muted", models.Blocked(c.GetString("lang"), "mut", user.Username))
 		if user.NoJs {
 			c.Set("nojschecked", "checked")
 		} else {
 			c.Set("nojschecked", "")
 		}
+		c.Set("commentmail", !user.NoCommentMail)
+		c.Set("followcomments", user.FollowComments)
 		c.HTML(http.StatusOK, "settings.html", c.Keys)
 	case "POST":
 		nojs := false
+		commentmail, followcomments := false, false
 		if c.Request.ParseForm() == nil {
 			nojsoption := c.Request.Form["nojsoption"]
 			if len(nojsoption) > 0 && nojsoption[0] == "nojs" {
 				nojs = true
 			}
+			commentmail = c.Request.Form.Get("commentmail") == "on"
+			followcomments = c.Request.Form.Get("followcomments") == "on"
 		}
 		//log.Println("isnogs", nojs)
 		var u models.User
@@ -479,13 +700,20 @@ func Settings(c *gin.Context) {
 			renderErr(c, err)
 			return
 		}
-
 		u.Lang = c.GetString("lang")
 		user, err := models.UserCheckGet(u.Lang, u.Username, u.Password)
 		if err != nil {
 			renderErr(c, err)
 			return
 		}
+		if c.Request.Header.Get("Content-type") == "application/json" {
+			// no checkboxes in json, keep stored
+			u.NoCommentMail = user.NoCommentMail
+			u.FollowComments = user.FollowComments
+		} else {
+			u.NoCommentMail = !commentmail
+			u.FollowComments = followcomments
+		}
 		//fmt.Printf("user:%+v\n", u)
 		if u.NewPassword != "" {
 			bytePassword := []byte(u.NewPassword)
@@ -496,6 +724,8 @@ func Settings(c *gin.Context) {
 			u.PasswordHash = string(passwordHash)
 			u.Type2Telegram = user.Type2Telegram
 			u.Type2TeleNoTxt = user.Type2TeleNoTxt
+			u.Created = user.Created
+			u.IP = user.IP
 
 			err = models.UserSave(&u)
 			if err != nil {
@@ -512,6 +742,8 @@ func Settings(c *gin.Context) {
 		u.PasswordHash = user.PasswordHash
 		u.Type2Telegram = user.Type2Telegram
 		u.Type2TeleNoTxt = user.Type2TeleNoTxt
+		u.Created = user.Created
+		u.IP = user.IP
 
 		err = models.UserSave(&u)
 		if err != nil {
@@ -524,7 +756,7 @@ func Settings(c *gin.Context) {
 			if u.NoJs {
 				isnojs = "true"
 			}
-			tokenString, err := genToken(u.Username, u.Image, isnojs)
+			tokenString, err := genToken(u.Username, u.Image, isnojs, u.Created)
 			if err != nil {
 				renderErr(c, err)
 				return
@@ -543,6 +775,102 @@ func Settings(c *gin.Context) {
 	}
 }
 
+// DataExport - download personal data of current user as zip or json
+func DataExport(c *gin.Context) {
+	username := c.GetString("username")
+	if username == "" {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	lang := c.GetString("lang")
+	data, err := models.UserExport(lang, username)
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	if c.Request.Header.Get("Content-type") == "application/json" {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+	host := "https://" + c.Request.Host + "/"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s-%s.zip", lang, username, time.Now().Format("20060102")))
+	z := zip.NewWriter(c.Writer)
+	add := func(name string, b []byte) error {
+		w, err := z.Create(name)
+		if err == nil {
+			_, err = w.Write(b)
+		}
+		return err
+	}
+	addJSON := func(name string, v interface{}) error {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, b)
+	}
+	err = addJSON("profile.json", data.Profile)
+	for i := range data.Articles {
+		if err == nil {
+			err = add(fmt.Sprintf("articles/%d.md", data.Articles[i].ID), articleMarkdown(host, &data.Articles[i]))
+		}
+	}
+	for i := range data.Drafts {
+		if err == nil {
+			err = add(fmt.Sprintf("drafts/%d.md", data.Drafts[i].ID), articleMarkdown("", &data.Drafts[i].Article))
+		}
+	}
+	for i := range data.Trash {
+		if err == nil {
+			err = add(fmt.Sprintf("trash/%d.md", data.Trash[i].Article.ID), articleMarkdown("", &data.Trash[i].Article))
+		}
+	}
+	for name, v := range map[string]interface{}{"comments.json": data.Comments, "follows.json": data.Follows,
+		"tags.json": data.Tags, "favorites.json": data.Favorites, "mentions.json": data.Mentions,
+		"blocked.json": data.Blocked, "muted.json": data.Muted, "notifications.json": data.Notifications} {
+		if err == nil {
+			err = addJSON(name, v)
+		}
+	}
+	for _, img := range data.Images {
+		if err != nil {
+			break
+		}
+		var b []byte
+		if b, err = ioutil.ReadFile(models.DataPath(img.Path)); err == nil {
+			err = add("images/"+img.Name, b)
+		}
+	}
+	if err == nil {
+		err = z.Close()
+	}
+	if err != nil {
+		log.Println("DataExport", username, err)
+	}
+}
+
+// articleMarkdown return markdown of article with metadata, url is added if host is set
+func articleMarkdown(host string, a *models.Article) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "---\ntitle: %q\n", a.Title)
+	if host != "" {
+		fmt.Fprintf(&b, "url: %s@%s/%d\n", host, a.Author, a.ID)
+	}
+	fmt.Fprintf(&b, "created: %s\n", a.CreatedAt.Format(time.RFC3339))
+	if !a.UpdatedAt.IsZero() {
+		fmt.Fprintf(&b, "updated: %s\n", a.UpdatedAt.Format(time.RFC3339))
+	}
+	if tags := a.TagList(); len(tags) > 0 {
+		fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(tags, ", "))
+	}
+	if a.OgImage != "" {
+		fmt.Fprintf(&b, "ogimage: %s\n", a.OgImage)
+	}
+	fmt.Fprintf(&b, "plus: %d\nminus: %d\n---\n\n%s\n", a.Plus, a.Minus, a.Body)
+	return b.Bytes()
+}
+
 // Logout remove cookie
 func Logout(c *gin.Context) {
 	switch c.Request.Method {
@@ -575,7 +903,7 @@ func Login(c *gin.Context) {
 		if user.NoJs {
 			isnojs = "true"
 		}
-		tokenString, err := genToken(user.Username, user.Image, isnojs)
+		tokenString, err := genToken(user.Username, user.Image, isnojs, user.Created)
 		if err != nil {
 			renderErr(c, err)
 			return
@@ -612,8 +940,23 @@ func Editor(c *gin.Context) {
 			c.Set("body", str)
 			c.Set("title", a.Title)
 			c.Set("ogimage", a.OgImage)
-			c.Set("tag", a.Tag)
+			c.Set("tag", strings.Join(a.TagList(), " "))
 			c.Set("uniqueid", strconv.Itoa(int(time.Now().Unix())))
+		} else if did, _ := strconv.Atoi(c.Query("draft")); did > 0 {
+			d, err := models.DraftGet(c.GetString("lang"), username, uint32(did))
+			if err != nil {
+				renderErr(c, err)
+				return
+			}
+			c.Set("did", d.ID)
+			c.Set("body", strings.Replace(d.Article.Body, "\n\n", "\r\n", -1))
+			c.Set("title", d.Article.Title)
+			c.Set("ogimage", d.Article.OgImage)
+			c.Set("tag", strings.Join(d.Article.TagList(), " "))
+			if !d.PublishAt.IsZero() {
+				c.Set("publishat", d.PublishAt.Format(publishAtLayout))
+			}
+			c.Set("uniqueid", "draft"+strconv.Itoa(int(d.ID)))
 		} else {
 			wait := models.PostLimitGet(c.GetString("lang"), c.GetString("username")) //ratelimit(postRate, RatePost)
 			if wait > 0 {
@@ -666,35 +1009,32 @@ func Editor(c *gin.Context) {
 		readingTime, wordCount := utils.ReadingTime(body)
 		unsafe := blackfriday.Run([]byte(body))
 		html := template.HTML(bluemonday.UGCPolicy().SanitizeBytes(unsafe))
-		tag := strings.TrimSpace(abind.Tag)
+		tags, err := models.ParseTags(strings.Join(append(abind.Tags, abind.Tag), " "))
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
 		title := strings.TrimSpace(abind.Title)
 		ogimage := strings.TrimSpace(abind.OgImage)
 		//log.Printf("ogimage:'%s'\n", ogimage)
 		var a models.Article
 		if aid > 0 {
 
-			a, err := models.ArticleGet(lang, username, uint32(aid))
-			if err != nil {
-				renderErr(c, err)
-				return
-			}
-			a.HTML = html
-			a.Body = body
-			a.Title = title
-			a.OgImage = ogimage
-			a.ReadingTime = readingTime
-			a.WordCount = wordCount
-			oldTag := a.Tag
-			a.Tag = tag
-			err = models.ArticleUpd(a, oldTag)
+			a, err := models.ArticleModify(lang, username, uint32(aid), func(a *models.Article) error {
+				a.HTML = html
+				a.Body = body
+				a.Title = title
+				a.OgImage = ogimage
+				a.ReadingTime = readingTime
+				a.WordCount = wordCount
+				a.SetTags(tags)
+				return nil
+			})
 			if err != nil {
 				renderErr(c, err)
 				return
 			}
-			send2telegram(c.GetString("lang"), c.GetString("username"), a.Body, a.Title,
-				fmt.Sprintf("https://%s.tgr.am/@%s/%d#comments", c.GetString("lang"), a.Author, a.ID), a.OgImage, a.ID)
-
-			send2fcm("/topics/"+c.GetString("lang")+"_all", a)
+			sendArticle(a)
 			//log.Println("aid2", a)
 			//log.Println("Author", a.Author, "a.ID", a.ID, fmt.Sprintf("/@%s/%d", a.Author, a.ID))
 
@@ -708,21 +1048,31 @@ func Editor(c *gin.Context) {
 
 			return
 		}
-		wait := models.PostLimitGet(c.GetString("lang"), c.GetString("username")) //ratelimit(postRate, RatePost)
-		if wait > 0 {
-			e := fmt.Sprintf("Rate limit for new users on new post, please wait: %d Seconds", wait)
-			renderErr(c, errors.New(e))
+		if ban := models.UserBanGet(c.GetString("lang"), username); ban != nil {
+			renderErr(c, ban)
 			return
 		}
-		if models.UserBanGet(username) { //_, bannedAuthor := cc.Get("ban:uid:" + username); bannedAuthor {
-			renderErr(c, errors.New("You are banned for 24 h for spam, advertising, illegal and / or copyrighted content. Sorry about that("))
+		if ban := models.IPBanGet(c.GetString("lang"), c.ClientIP()); ban != nil {
+			renderErr(c, ban)
 			return
 		}
-		/*
-			if _, bannedIP := cc.Get(c.ClientIP()); bannedIP {
-				renderErr(c, errors.New("This ip was banned for 24 h for spam, advertising, illegal and / or copyrighted content. Sorry about that("))
+		did, _ := strconv.Atoi(formValue(c, "did"))
+		var publishAt time.Time
+		if at := formValue(c, "publishat"); at != "" {
+			if publishAt, err = parsePublishAt(at); err != nil {
+				renderErr(c, err)
 				return
-			}*/
+			}
+		}
+		isDraft := formValue(c, "draft") != "" || publishAt.After(time.Now())
+		if !isDraft {
+			wait := models.PostLimitGet(c.GetString("lang"), c.GetString("username")) //ratelimit(postRate, RatePost)
+			if wait > 0 {
+				e := fmt.Sprintf("Rate limit for new users on new post, please wait: %d Seconds", wait)
+				renderErr(c, errors.New(e))
+				return
+			}
+		}
 		a.Lang = lang
 		a.Author = username
 		a.Image = c.GetString("image")
@@ -733,20 +1083,48 @@ func Editor(c *gin.Context) {
 		a.Title = title
 		a.ReadingTime = readingTime
 		a.WordCount = wordCount
-		a.Tag = tag
-		newaid, err := models.ArticleNew(&a)
-		if err != nil {
-			renderErr(c, err)
-			return
+		a.SetTags(tags)
+		if isDraft || did > 0 {
+			if username == "" {
+				renderErr(c, errors.New("Login first"))
+				return
+			}
+			d := &models.Draft{ID: uint32(did), Article: a}
+			if isDraft {
+				d.PublishAt = publishAt
+			}
+			if _, err = models.DraftSave(lang, username, d); err != nil {
+				renderErr(c, err)
+				return
+			}
+			if isDraft {
+				switch c.Request.Header.Get("Content-type") {
+				case "application/json":
+					c.JSON(http.StatusOK, d)
+				default:
+					c.Redirect(http.StatusFound, "/drafts")
+				}
+				return
+			}
+			// publish saved draft
+			p, err := models.DraftPublish(lang, username, d.ID)
+			if err != nil {
+				renderErr(c, err)
+				return
+			}
+			a = *p
+		} else {
+			newaid, err := models.ArticleNew(&a)
+			if err != nil {
+				renderErr(c, err)
+				return
+			}
+			a.ID = newaid
 		}
-		a.ID = newaid
 		// add to cache on success
 		models.PostLimitSet(c.GetString("lang"), c.GetString("username"))
 		//cc.Set(postRate, time.Now().Unix(), cache.DefaultExpiration)
-		send2telegram(c.GetString("lang"), c.GetString("username"), a.Body, a.Title,
-			fmt.Sprintf("https://%s.tgr.am/@%s/%d#comments", c.GetString("lang"), a.Author, a.ID), a.OgImage, a.ID)
-
-		send2fcm("/topics/"+c.GetString("lang")+"_all", &a)
+		sendArticle(&a)
 		switch c.Request.Header.Get("Content-type") {
 		case "application/json":
 			// Respond with JSON
@@ -758,60 +1136,200 @@ func Editor(c *gin.Context) {
 	}
 }
 
-func send2fcm(to string, a *models.Article) {
-	if Config.FCMAuth == "" {
-		return
+// publishAtLayout - format of datetime-local input
+const publishAtLayout = "2006-01-02T15:04"
+
+// parsePublishAt parse time from datetime-local input in server time zone or RFC3339
+func parsePublishAt(s string) (t time.Time, err error) {
+	if t, err = time.ParseInLocation(publishAtLayout, s, time.Local); err == nil {
+		return t, nil
 	}
-	a.Body = GetLead(a.Body)
-	a.HTML = ""
-	b := models.Send2fcm(to, a)
-	//log.Println(string(b))
-	if b != nil {
-		defHeaders := map[string]string{
-			"Authorization": "key=" + Config.FCMAuth,
-			"Content-type":  "application/json",
-		}
-		/*t := */ utils.HTTPPostJson("https://fcm.googleapis.com/fcm/send", defHeaders, b)
-		//if t != nil {
-		//fmt.Println(string(t))
-		//}
+	if t, err = time.Parse(time.RFC3339, s); err != nil {
+		return t, errors.New("Wrong publish time, use: " + publishAtLayout)
 	}
+	return t, nil
 }
 
-func send2telegram(lang, username, text, title, link, img string, aid uint32) {
-	u, err := models.UserGet(lang, username)
-	if err == nil && u.Type2Telegram != "" {
-		msgID := models.Type2TeleGet(aid)
-		if u.Type2TeleNoTxt {
-			text = ""
-		}
-		mid := models.TgSendMsg(Config.Type2TeleBot, u.Type2Telegram, text, title, link, img, msgID)
-		if mid != 0 {
-			//mid will be 0 in case of error
-			models.Type2TeleSet(aid, mid)
-		}
+// formValue return value from form or from query for json clients
+func formValue(c *gin.Context, key string) string {
+	if v := c.PostForm(key); v != "" {
+		return v
 	}
+	return c.Query(key)
 }
 
-// Article page
-// curl --header "Content-type:application/json"   'http://sub.localhost:8081/@recoilme/38'
-func Article(c *gin.Context) {
-	switch c.Request.Method {
-	case "GET":
-		lang := c.GetString("lang")
-		aid, _ := strconv.Atoi(c.Param("aid"))
-		aid32 := models.Uint32toBin(uint32(aid))
-		username := c.Param("username")
-		a, err := models.ArticleGet(lang, username, uint32(aid))
+// sendArticle notify telegram and fcm subscribers about published article
+func sendArticle(a *models.Article) {
+	send2telegram(a.Lang, a.Author, a.Body, a.Title,
+		fmt.Sprintf("https://%s.tgr.am/@%s/%d#comments", a.Lang, a.Author, a.ID), a.OgImage, a.ID)
+
+	send2fcm("/topics/"+a.Lang+"_all", a)
+}
+
+// PublishScheduled publish drafts with publish time in past, called by background publisher
+func PublishScheduled() (published int) {
+	for _, s := range models.DraftsDue(time.Now()) {
+		if ban := models.UserBanGet(s.Lang, s.Author); ban != nil {
+			// wait for ban end
+			continue
+		}
+		a, err := models.DraftPublish(s.Lang, s.Author, s.DID)
 		if err != nil {
-			renderErr(c, err)
-			return
+			log.Println("Publish draft:", s.Lang, s.Author, s.DID, err)
+			continue
+		}
+		sendArticle(a)
+		published++
+	}
+	return published
+}
+
+// Drafts - drafts of current user
+func Drafts(c *gin.Context) {
+	if c.GetString("username") == "" {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	drafts := models.Drafts(c.GetString("lang"), c.GetString("username"))
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		if drafts == nil {
+			drafts = make([]models.Draft, 0)
+		}
+		c.JSON(http.StatusOK, drafts)
+	default:
+		c.Set("drafts", drafts)
+		c.HTML(http.StatusOK, "drafts.html", c.Keys)
+	}
+}
+
+// DraftDel - delete draft of current user
+func DraftDel(c *gin.Context) {
+	did, _ := strconv.Atoi(c.Param("did"))
+	if c.GetString("token") != c.PostForm("token") {
+		renderErr(c, errors.New("Invalid token("))
+		return
+	}
+	if err := models.DraftDelete(c.GetString("lang"), c.GetString("username"), uint32(did)); err != nil {
+		renderErr(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, "/drafts")
+}
+
+// DraftPublish - publish draft of current user now
+func DraftPublish(c *gin.Context) {
+	lang := c.GetString("lang")
+	username := c.GetString("username")
+	did, _ := strconv.Atoi(c.Param("did"))
+	if c.GetString("token") != c.PostForm("token") {
+		renderErr(c, errors.New("Invalid token("))
+		return
+	}
+	if wait := models.PostLimitGet(lang, username); wait > 0 {
+		renderErr(c, fmt.Errorf("Rate limit for new users on new post, please wait: %d Seconds", wait))
+		return
+	}
+	if ban := models.UserBanGet(lang, username); ban != nil {
+		renderErr(c, ban)
+		return
+	}
+	if ban := models.IPBanGet(lang, c.ClientIP()); ban != nil {
+		renderErr(c, ban)
+		return
+	}
+	a, err := models.DraftPublish(lang, username, uint32(did))
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	models.PostLimitSet(lang, username)
+	sendArticle(a)
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		c.JSON(http.StatusOK, a)
+	default:
+		c.Redirect(http.StatusFound, fmt.Sprintf("/@%s/%d", a.Author, a.ID))
+	}
+}
+
+func send2fcm(to string, a *models.Article) {
+	if Config.FCMAuth == "" {
+		return
+	}
+	a.Body = GetLead(a.Body)
+	a.HTML = ""
+	b := models.Send2fcm(to, a)
+	//log.Println(string(b))
+	if b != nil {
+		defHeaders := map[string]string{
+			"Authorization": "key=" + Config.FCMAuth,
+			"Content-type":  "application/json",
+		}
+		/*t := */ utils.HTTPPostJson("https://fcm.googleapis.com/fcm/send", defHeaders, b)
+		//if t != nil {
+		//fmt.Println(string(t))
+		//}
+	}
+}
+
+func send2telegram(lang, username, text, title, link, img string, aid uint32) {
+	u, err := models.UserGet(lang, username)
+	if err == nil && u.Type2Telegram != "" {
+		msgID := models.Type2TeleGet(aid)
+		if u.Type2TeleNoTxt {
+			text = ""
+		}
+		mid := models.TgSendMsg(Config.Type2TeleBot, u.Type2Telegram, text, title, link, img, msgID)
+		if mid != 0 {
+			//mid will be 0 in case of error
+			models.Type2TeleSet(aid, mid)
+		}
+	}
+}
+
+// Article page
+// curl --header "Content-type:application/json"   'http://sub.localhost:8081/@recoilme/38'
+func Article(c *gin.Context) {
+	switch c.Request.Method {
+	case "GET":
+		// same route as article
+		if dir := c.Param("aid"); dir == "followers" || dir == "following" {
+			Follows(c, dir)
+			return
+		}
+		lang := c.GetString("lang")
+		aid, _ := strconv.Atoi(c.Param("aid"))
+		aid32 := models.Uint32toBin(uint32(aid))
+		username := c.Param("username")
+		a, err := models.ArticleGet(lang, username, uint32(aid))
+		if err != nil {
+			renderErr(c, err)
+			return
 		}
 		path := c.GetString("path")
 		if username != "" {
 			url := "/@" + username + "/" + c.Param("aid")
 			models.MentionDel(lang, c.GetString("username"), url)
+			models.NotifyRead(lang, c.GetString("username"), a.ID)
+		}
+		// comments
+		cpage, _ := strconv.Atoi(c.Query("cp"))
+		comments, cnt, threads := models.Comments(lang, a.ID, cpage)
+		a.Comments, a.CommentCnt = comments, cnt
+		a.Comments = models.Unmuted(a.Comments, models.Muted(lang, c.GetString("username")))
+		if reply, _ := strconv.Atoi(c.Query("reply")); reply > 0 {
+			if com, err := models.CommentGet(lang, a.ID, uint32(reply)); err == nil && !com.Deleted {
+				c.Set("reply", com)
+			}
+		}
+		c.Set("cpage", cpage)
+		c.Set("cprev", cpage-1)
+		if (cpage+1)*models.CommentsPage < threads {
+			c.Set("cnext", cpage+1)
 		}
+		c.Set("myvote", models.VoteGet(lang, c.GetString("username"), a.ID))
+		c.Set("cvotes", models.CommentVotes(lang, c.GetString("username"), a.Comments))
 		c.Set("link", "https://"+c.Request.Host+path)
 		c.Set("article", a)
 		c.Set("title", a.Title)
@@ -827,6 +1345,7 @@ func Article(c *gin.Context) {
 		c.Set("isfav", isFav)
 		favcnt := models.FollowCount(lang, "fav", string(aid32))
 		c.Set("favcnt", favcnt)
+		c.Set("amuted", models.IsArticleMuted(lang, a.ID, c.GetString("username")))
 		//log.Println("Art", a)
 
 		// view counter
@@ -855,6 +1374,87 @@ func Article(c *gin.Context) {
 	}
 }
 
+// Revisions - previous versions of article, diff of revision with next version
+func Revisions(c *gin.Context) {
+	lang := c.GetString("lang")
+	author := c.Param("username")
+	aid, _ := strconv.Atoi(c.Param("aid"))
+	a, err := models.ArticleGet(lang, author, uint32(aid))
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	revs := models.Revisions(lang, a.ID)
+	c.Set("article", a)
+	c.Set("revs", revs)
+	c.Set("title", a.Title)
+	if rev, _ := strconv.Atoi(c.Query("rev")); rev > 0 {
+		found := false
+		for i, r := range revs {
+			if r.Rev != uint32(rev) {
+				continue
+			}
+			next := a
+			if i > 0 {
+				next = &revs[i-1].Article
+			}
+			found = true
+			c.Set("rev", r.Rev)
+			c.Set("diff", utils.LineDiff(revisionText(&r.Article), revisionText(next)))
+		}
+		if !found {
+			renderErr(c, errors.New("Revision not found"))
+			return
+		}
+	}
+
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		for i := range revs {
+			revs[i].Article.HTML = ""
+		}
+		c.JSON(http.StatusOK, gin.H{"revisions": revs, "diff": c.Keys["diff"]})
+	default:
+		c.HTML(http.StatusOK, "revisions.html", c.Keys)
+	}
+}
+
+// revisionText return text of article for diff
+func revisionText(a *models.Article) string {
+	text := a.Title
+	if tags := a.TagList(); len(tags) > 0 {
+		text += "\n#" + strings.Join(tags, " #")
+	}
+	return text + "\n\n" + a.Body
+}
+
+// RevisionRestore - author restore article from revision
+func RevisionRestore(c *gin.Context) {
+	lang := c.GetString("lang")
+	username := c.GetString("username")
+	if username != c.Param("username") {
+		renderErr(c, errors.New("Only author may restore article"))
+		return
+	}
+	if c.GetString("token") != c.PostForm("token") {
+		renderErr(c, errors.New("Invalid token("))
+		return
+	}
+	aid, _ := strconv.Atoi(c.Param("aid"))
+	rev, _ := strconv.Atoi(c.Param("rev"))
+	a, err := models.RevisionRestore(lang, username, uint32(aid), uint32(rev))
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		c.JSON(http.StatusOK, a)
+	default:
+		c.Redirect(http.StatusFound, fmt.Sprintf("/@%s/%d", a.Author, a.ID))
+	}
+}
+
 // ArticleDelete delete page by id of current user
 func ArticleDelete(c *gin.Context) {
 	switch c.Request.Method {
@@ -872,6 +1472,131 @@ func ArticleDelete(c *gin.Context) {
 		a := new(models.Article)
 		a.ID = uint32(aid)
 		send2fcm("/topics/"+c.GetString("lang")+"_del", a)
+		c.Redirect(http.StatusFound, "/trash")
+	}
+}
+
+// Trash - deleted articles of current user
+func Trash(c *gin.Context) {
+	username := c.GetString("username")
+	if username == "" {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	trash := models.Trash(c.GetString("lang"), username)
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		if trash == nil {
+			trash = make([]models.Trashed, 0)
+		}
+		c.JSON(http.StatusOK, trash)
+	default:
+		c.Set("trash", trash)
+		c.HTML(http.StatusOK, "trash.html", c.Keys)
+	}
+}
+
+// TrashRestore - restore article of current user from trash
+// articles deleted by moderator may be restored by admin only
+func TrashRestore(c *gin.Context) {
+	lang := c.GetString("lang")
+	username := c.GetString("username")
+	author := c.Param("author")
+	aid, _ := strconv.Atoi(c.Param("aid"))
+	if c.GetString("token") != c.PostForm("token") {
+		renderErr(c, errors.New("Invalid token("))
+		return
+	}
+	t, err := models.TrashGet(lang, author, uint32(aid))
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	if username != Config.Admin && (username != author || t.DeletedBy != author) {
+		renderErr(c, errors.New("Article was removed by moderator"))
+		return
+	}
+	a, err := models.ArticleRestore(lang, author, uint32(aid))
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		c.JSON(http.StatusOK, a)
+	default:
+		c.Redirect(http.StatusFound, fmt.Sprintf("/@%s/%d", a.Author, a.ID))
+	}
+}
+
+// TrashPurge - delete article from trash forever, by author if he deleted it or by admin
+func TrashPurge(c *gin.Context) {
+	lang := c.GetString("lang")
+	username := c.GetString("username")
+	author := c.Param("author")
+	aid, _ := strconv.Atoi(c.Param("aid"))
+	if c.GetString("token") != c.PostForm("token") {
+		renderErr(c, errors.New("Invalid token("))
+		return
+	}
+	t, err := models.TrashGet(lang, author, uint32(aid))
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	if username != Config.Admin && (username != author || t.DeletedBy != author) {
+		renderErr(c, errors.New("Article was removed by moderator"))
+		return
+	}
+	if err = models.ArticlePurge(lang, author, uint32(aid)); err != nil {
+		renderErr(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, "/trash")
+}
+
+// AccountDelete - confirm and erase account, by user with password or by admin
+func AccountDelete(c *gin.Context) {
+	lang := c.GetString("lang")
+	username := c.GetString("username")
+	target := c.Param("username")
+	self := username == target
+	if username == "" || (!self && username != Config.Admin) {
+		renderErr(c, errors.New("You can delete only your account"))
+		return
+	}
+	if !models.UserExists(lang, target) {
+		renderErr(c, errors.New("User not found"))
+		return
+	}
+	switch c.Request.Method {
+	case "GET":
+		c.Set("target", target)
+		c.Set("self", self)
+		c.HTML(http.StatusOK, "delete.html", c.Keys)
+	case "POST":
+		if c.GetString("token") != c.PostForm("token") {
+			renderErr(c, errors.New("Invalid token("))
+			return
+		}
+		if c.PostForm("confirm") != target {
+			renderErr(c, errors.New("Type username to confirm"))
+			return
+		}
+		if self {
+			if _, err := models.UserCheckGet(lang, target, c.PostForm("password")); err != nil {
+				renderErr(c, err)
+				return
+			}
+		}
+		if err := models.UserDelete(lang, target); err != nil {
+			renderErr(c, err)
+			return
+		}
+		log.Printf("%s: account @%s deleted by @%s\n", lang, target, username)
+		if self {
+			c.SetCookie("token", "", 0, "/", "", false, true)
+		}
 		c.Redirect(http.StatusFound, "/")
 	}
 }
@@ -888,10 +1613,43 @@ func Follow(c *gin.Context) {
 			renderErr(c, err)
 			return
 		}
+		models.NotifyNew(c.GetString("lang"), user, models.Notification{Kind: models.NotifyFollow, By: username, Path: "/@" + username})
 		c.Redirect(http.StatusFound, action)
 	}
 }
 
+// Follows - followers of author or authors followed by author, ?p= for page
+// curl --header "Content-type:application/json" 'http://sub.localhost:8081/@recoilme/followers'
+func Follows(c *gin.Context, dir string) {
+	author := c.Param("username")
+	page, _ := strconv.Atoi(c.Query("p"))
+	users, cnt, err := models.FollowList(c.GetString("lang"), author, dir, c.GetString("username"), page)
+	if err != nil {
+		renderErr(c, err)
+		return
+	}
+	if users == nil {
+		users = []models.FollowUser{}
+	}
+	switch c.Request.Header.Get("Content-type") {
+	case "application/json":
+		c.JSON(http.StatusOK, gin.H{"username": author, "dir": dir, "count": cnt, "page": page, "users": users})
+	default:
+		c.Set("author", author)
+		c.Set("dir", dir)
+		c.Set("users", users)
+		c.Set("cnt", cnt)
+		c.Set("p", page)
+		if page > 0 {
+			c.Set("prev", page-1)
+		}
+		if (page+1)*models.FollowsPage < cnt {
+			c.Set("next", page+1)
+		}
+		c.HTML(http.StatusOK, "follows.html", c.Keys)
+	}
+}
+
 // Unfollow unsubscribe
 func Unfollow(c *gin.Context) {
 	switch c.Request.Method {
@@ -907,6 +1665,71 @@ func Unfollow(c *gin.Context) {
 	}
 }
 
+// TagFollow subscribe on articles of tag
+func TagFollow(c *gin.Context) {
+	switch c.Request.Method {
+	case "GET":
+		err := models.FollowTag(c.GetString("lang"), c.Param("name"), c.GetString("username"))
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, c.Param("action"))
+	}
+}
+
+// TagUnfollow unsubscribe from tag
+func TagUnfollow(c *gin.Context) {
+	switch c.Request.Method {
+	case "GET":
+		err := models.Unfollowing(c.GetString("lang"), "tag", c.Param("name"), c.GetString("username"))
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, c.Param("action"))
+	}
+}
+
+// Block forbid user to comment, mention and follow
+func Block(c *gin.Context) {
+	block(c, "blk", true)
+}
+
+// Unblock remove user from blocked
+func Unblock(c *gin.Context) {
+	block(c, "blk", false)
+}
+
+// Mute hide articles and comments of user
+func Mute(c *gin.Context) {
+	block(c, "mut", true)
+}
+
+// Unmute remove user from muted
+func Unmute(c *gin.Context) {
+	block(c, "mut", false)
+}
+
+// block add or remove user of block ("blk") or mute ("mut") list
+func block(c *gin.Context, cat string, on bool) {
+	switch c.Request.Method {
+	case "GET":
+		lang, username, user := c.GetString("lang"), c.GetString("username"), c.Param("user")
+		var err error
+		if on {
+			err = models.Block(lang, cat, username, user)
+		} else {
+			err = models.Unblock(lang, cat, username, user)
+		}
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, c.Param("action"))
+	}
+}
+
 // Fav add to favorites
 func Fav(c *gin.Context) {
 	switch c.Request.Method {
@@ -922,6 +1745,10 @@ func Fav(c *gin.Context) {
 			renderErr(c, err)
 			return
 		}
+		if author := models.ArticleAuthor(c.GetString("lang"), uint32(aid)); author != "" {
+			models.NotifyNew(c.GetString("lang"), author, models.Notification{Kind: models.NotifyFavorite, By: username,
+				Aid: uint32(aid), Path: fmt.Sprintf("/@%s/%d", author, aid)})
+		}
 		c.Redirect(http.StatusFound, action)
 	}
 }
@@ -942,6 +1769,35 @@ func Unfav(c *gin.Context) {
 	}
 }
 
+// ArticleMute stop notifications about comments of article
+func ArticleMute(c *gin.Context) {
+	articleMute(c, true)
+}
+
+// ArticleUnmute resume notifications about comments of article
+func ArticleUnmute(c *gin.Context) {
+	articleMute(c, false)
+}
+
+func articleMute(c *gin.Context, on bool) {
+	switch c.Request.Method {
+	case "GET":
+		aid, _ := strconv.Atoi(c.Param("aid"))
+		lang, username := c.GetString("lang"), c.GetString("username")
+		var err error
+		if on {
+			err = models.ArticleMute(lang, uint32(aid), username)
+		} else {
+			err = models.ArticleUnmute(lang, uint32(aid), username)
+		}
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, c.Param("action"))
+	}
+}
+
 // GoToRegister redirect to registration
 func GoToRegister() gin.HandlerFunc {
 	//log.Println("GoToRegister")
@@ -980,6 +1836,8 @@ func Author(c *gin.Context) {
 	c.Set("author", author)
 	isFolow := models.IsFollowing(lang, "fol", authorStr, c.GetString("username"))
 	c.Set("isfollow", isFolow)
+	c.Set("isblocked", models.IsBlocked(lang, c.GetString("username"), authorStr))
+	c.Set("ismuted", models.IsFollowing(lang, "mut", authorStr, c.GetString("username")))
 	followcnt := models.FollowCount(lang, "fol", authorStr)
 	c.Set("followcnt", followcnt)
 
@@ -1026,6 +1884,10 @@ func CommentNew(c *gin.Context) {
 				}
 			}
 		}
+		if ban := models.UserBanGet(lang, c.GetString("username")); ban != nil {
+			renderErr(c, ban)
+			return
+		}
 		//rateComKey := lang + ":c:" + c.GetString("username")
 		wait := models.ComLimitGet(lang, c.GetString("username")) //ratelimit(rateComKey, RateComment)
 		if wait > 0 {
@@ -1054,9 +1916,16 @@ func CommentNew(c *gin.Context) {
 		}
 		ment := GetLead(a.Body)
 		url := "/@" + username + "/" + c.Param("aid")
-		fullurl := url + "#comment" + strconv.Itoa(int(cid))
+		fullurl := commentURL(lang, username, uint32(aid), cid)
 		mentions := models.MentionNew(a.Body, lang, ment, a.Author, url, fullurl, uint32(aid), cid)
 		models.SendMentions(lang, Config.SMTPHost, Config.SMTPPort, Config.SMTPUser, Config.SMTPPassword, Config.Domain, mentions)
+		var mentioned []string
+		for _, m := range mentions {
+			mentioned = append(mentioned, m.ToUsername)
+		}
+		n := models.Notification{By: a.Author, Aid: uint32(aid), Cid: cid, Path: fullurl, Text: ment}
+		notified := models.CommentNotify(lang, username, n, mentioned)
+		go models.SendComments(lang, Config.SMTPHost, Config.SMTPPort, Config.SMTPUser, Config.SMTPPassword, Config.Domain, n, notified)
 		// add to cache on success
 		models.ComLimitSet(lang, c.GetString("username"))
 
@@ -1065,7 +1934,7 @@ func CommentNew(c *gin.Context) {
 			// Respond with JSON
 			c.JSON(http.StatusOK, a)
 		default:
-			c.Redirect(http.StatusFound, fmt.Sprintf("/@%s/%d#comment%d", username, aid, cid))
+			c.Redirect(http.StatusFound, fullurl)
 		}
 
 	}
@@ -1102,25 +1971,30 @@ func ArticleBad(c *gin.Context) {
 		username := c.GetString("username")
 
 		//check for me
-		if username != "recoilme" {
-			renderErr(c, errors.New("You are not recoilme"))
+		if username != Config.Admin {
+			renderErr(c, errors.New("You are not admin"))
 			return
 		}
 		// check for not me
-		if author == "recoilme" {
-			renderErr(c, errors.New("You are recoilme!"))
+		if author == Config.Admin {
+			renderErr(c, errors.New("You are admin!"))
 			return
 		}
-		err := models.ArticleDelete(c.GetString("lang"), author, uint32(aid))
+		err := models.ArticleTrash(c.GetString("lang"), author, uint32(aid), username)
 		if err != nil {
 			renderErr(c, err)
 			return
 		}
 		if bad == "bad" {
-			models.UserBanSet(author)
+			reason := "spam, advertising, illegal and / or copyrighted content"
+			if _, err = models.BanSet(c.GetString("lang"), author, false, reason, username, models.BanTime); err != nil {
+				renderErr(c, err)
+				return
+			}
+			if u, err := models.UserGet(c.GetString("lang"), author); err == nil && u.IP != "" {
+				models.BanSet(c.GetString("lang"), u.IP, true, reason, username, models.BanTime)
+			}
 		}
-		//cc.Set("ban:uid:"+author, time.Now().Unix(), cache.DefaultExpiration)
-		//}
 		a := new(models.Article)
 		a.ID = uint32(aid)
 		send2fcm("/topics/"+c.GetString("lang")+"_del", a)
@@ -1207,37 +2081,54 @@ func CommentUp(c *gin.Context) {
 		aid := c.Param("aid")
 		cid := c.Param("cid")
 		//log.Println("user", author, aid, cid)
-		if authorCom == username {
+		aidint, _ := strconv.Atoi(aid)
+		cidint, _ := strconv.Atoi(cid)
+		com, err := models.CommentGet(lang, uint32(aidint), uint32(cidint))
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		if authorCom == username || com.Author == username {
 			// no myself vote
 			renderErr(c, errors.New("You may not vote for yourself("))
 			return
 		}
-		err := models.ComUpSet(lang, username, cid)
+		if models.CommentVoteGet(lang, username, uint32(cidint)) {
+			renderErr(c, errors.New("Oh: only one vote for each comment allowed("))
+			return
+		}
+		err = models.ComUpSet(lang, username)
 		if err != nil {
 			renderErr(c, err)
 			return
 		}
 		// store vote
-		aidint, _ := strconv.Atoi(aid)
-		a, err := models.ArticleGet(lang, authorArt, uint32(aidint))
+		_, err = models.CommentVote(lang, username, uint32(aidint), uint32(cidint), true)
 		if err != nil {
 			renderErr(c, err)
 			return
 		}
-		//log.Println("a:", a)
-		comments := a.Comments
-		cidint, _ := strconv.Atoi(cid)
+		models.NotifyNew(lang, com.Author, models.Notification{Kind: models.NotifyVote, By: username, Aid: uint32(aidint),
+			Cid: com.ID, Path: commentURL(lang, authorArt, uint32(aidint), uint32(cidint)), Text: GetLead(com.Body)})
 
-		for ind, com := range comments {
-			if com.ID == uint32(cidint) {
-				votes := com.Plus
-				a.Comments[ind].Plus = votes + 1
-				models.ArticleUpd(a, a.Tag)
-				break
-			}
-		}
+		c.Redirect(http.StatusFound, commentURL(lang, authorArt, uint32(aidint), uint32(cidint)))
+	}
+}
 
-		c.Redirect(http.StatusFound, fmt.Sprintf("/@%s/%s#comment%s", authorArt, aid, cid))
+// CommentRetract remove vote from comment
+func CommentRetract(c *gin.Context) {
+	switch c.Request.Method {
+	case "GET":
+		authorArt := c.Param("authorart")
+		lang := c.GetString("lang")
+		aidint, _ := strconv.Atoi(c.Param("aid"))
+		cidint, _ := strconv.Atoi(c.Param("cid"))
+		_, err := models.CommentVote(lang, c.GetString("username"), uint32(aidint), uint32(cidint), false)
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, commentURL(lang, authorArt, uint32(aidint), uint32(cidint)))
 	}
 }
 
@@ -1250,44 +2141,45 @@ func CommentDel(c *gin.Context) {
 		lang := c.GetString("lang")
 		aid := c.Param("aid")
 		cid := c.Param("cid")
-		prevcom := uint32(0)
 
 		if authorCom != username && authorArt != username {
 			renderErr(c, errors.New("You may not delete this comment("))
 			return
 		}
 
-		// get article
 		aidint, _ := strconv.Atoi(aid)
-		a, err := models.ArticleGet(lang, authorArt, uint32(aidint))
+		cidint, _ := strconv.Atoi(cid)
+		com, err := models.CommentGet(lang, uint32(aidint), uint32(cidint))
 		if err != nil {
 			renderErr(c, err)
 			return
 		}
-		//log.Println("a:", a)
-		comments := a.Comments
-		newcomments := make([]models.Article, 0)
-		cidint, _ := strconv.Atoi(cid)
-
-		found := false
-		for _, com := range comments {
-			if !found {
-				prevcom = com.ID
-			}
-
-			if com.ID == uint32(cidint) {
-				found = true
-				continue
+		if com.Author != username {
+			// article author may delete any comment
+			if _, err = models.ArticleGet(lang, username, uint32(aidint)); err != nil {
+				renderErr(c, errors.New("You may not delete this comment("))
+				return
 			}
-			newcomments = append(newcomments, com)
 		}
-		a.Comments = newcomments
-		models.ArticleUpd(a, a.Tag)
+		prevcom, err := models.CommentDelete(lang, uint32(aidint), uint32(cidint))
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
 
-		c.Redirect(http.StatusFound, fmt.Sprintf("/@%s/%s#comment%d", authorArt, aid, prevcom))
+		c.Redirect(http.StatusFound, commentURL(lang, authorArt, uint32(aidint), prevcom))
 	}
 }
 
+// commentURL return link to comment on its page of comments
+func commentURL(lang, author string, aid, cid uint32) string {
+	url := fmt.Sprintf("/@%s/%d", author, aid)
+	if page := models.CommentPage(lang, aid, cid); page > 0 {
+		url += fmt.Sprintf("?cp=%d", page)
+	}
+	return url + fmt.Sprintf("#comment%d", cid)
+}
+
 func Vote(c *gin.Context) {
 	switch c.Request.Method {
 	case "GET":
@@ -1305,30 +2197,110 @@ func Vote(c *gin.Context) {
 			return
 		}
 
-		err := models.VoteSet(lang, username)
+		dir := models.VoteRetract
+		switch mode {
+		case "up":
+			dir = models.VoteUp
+		case "down":
+			dir = models.VoteDown
+		case "retract":
+		default:
+			renderErr(c, errors.New("Not implemented"))
+			return
+		}
+		aidint, _ := strconv.Atoi(aid)
+		if dir != models.VoteRetract && models.VoteGet(lang, username, uint32(aidint)) == models.VoteRetract {
+			// only new vote spends daily limit, flip and retract are free
+			err := models.VoteSet(lang, username)
+			if err != nil {
+				renderErr(c, err)
+				return
+			}
+		}
+		// store vote
+		a, err := models.ArticleVote(lang, username, author, uint32(aidint), dir)
 		if err != nil {
 			renderErr(c, err)
 			return
 		}
-		// store vote
-		aidint, _ := strconv.Atoi(aid)
-		a, err := models.ArticleGet(lang, author, uint32(aidint))
+		if dir != models.VoteRetract {
+			models.NotifyNew(lang, author, models.Notification{Kind: models.NotifyVote, By: username, Aid: a.ID,
+				Path: fmt.Sprintf("/@%s/%d", author, a.ID), Text: mode})
+		}
+
+		switch c.Request.Header.Get("Content-type") {
+		case "application/json":
+			c.JSON(http.StatusOK, gin.H{"plus": a.Plus, "minus": a.Minus, "vote": dir})
+		default:
+			c.Redirect(http.StatusFound, fmt.Sprintf("/@%s/%s#comments", author, aid))
+		}
+	}
+}
+
+// Bans list active bans and ban user or ip, admin only
+func Bans(c *gin.Context) {
+	if c.GetString("username") != Config.Admin {
+		renderErr(c, errors.New("You are not admin"))
+		return
+	}
+	switch c.Request.Method {
+	case "POST":
+		if c.GetString("token") != c.PostForm("token") {
+			renderErr(c, errors.New("Invalid token("))
+			return
+		}
+		hours, _ := strconv.Atoi(c.PostForm("hours"))
+		_, err := models.BanSet(c.GetString("lang"), strings.TrimSpace(c.PostForm("target")), c.PostForm("kind") == "ip",
+			strings.TrimSpace(c.PostForm("reason")), c.GetString("username"), time.Duration(hours)*time.Hour)
 		if err != nil {
 			renderErr(c, err)
 			return
 		}
-		switch mode {
-		case "up":
-			a.Plus = a.Plus + 1
-		case "down":
-			a.Minus = a.Minus + 1
+		c.Redirect(http.StatusFound, "/bans")
+	case "GET":
+		bans := models.Bans(c.GetString("lang"))
+		switch c.Request.Header.Get("Content-type") {
+		case "application/json":
+			c.JSON(http.StatusOK, bans)
 		default:
-			renderErr(c, errors.New("Not implemented"))
+			c.Set("bans", bans)
+			c.HTML(http.StatusOK, "bans.html", c.Keys)
+		}
+	}
+}
+
+// Unban lift ban, admin only
+func Unban(c *gin.Context) {
+	switch c.Request.Method {
+	case "POST":
+		if c.GetString("username") != Config.Admin {
+			renderErr(c, errors.New("You are not admin"))
 			return
 		}
-		models.ArticleUpd(a, a.Tag)
+		if c.GetString("token") != c.PostForm("token") {
+			renderErr(c, errors.New("Invalid token("))
+			return
+		}
+		err := models.BanLift(c.GetString("lang"), c.PostForm("target"), c.PostForm("kind") == "ip")
+		if err != nil {
+			renderErr(c, err)
+			return
+		}
+		c.Redirect(http.StatusFound, "/bans")
+	}
+}
 
-		c.Redirect(http.StatusFound, fmt.Sprintf("/@%s/%s#comments", author, aid))
+// Backup stream archive of language, admin only
+func Backup(c *gin.Context) {
+	if c.GetString("username") != Config.Admin {
+		renderErr(c, errors.New("You are not admin"))
+		return
+	}
+	lang := c.GetString("lang")
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.jsonl", lang, time.Now().Format("20060102")))
+	if _, err := models.Export(lang, c.Writer); err != nil {
+		log.Println("Backup", lang, err)
 	}
 }
 
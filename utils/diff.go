@@ -0,0 +1,88 @@
+package utils
+
+import "strings"
+
+// DiffLine - line of diff, Op is "+" for added, "-" for removed, " " for same
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// diffMax - max product of line counts for lcs, larger texts are replaced as whole
+const diffMax = 4 << 20
+
+// LineDiff return line diff between old and new text
+func LineDiff(old, new string) (diff []DiffLine) {
+	a := strings.Split(strings.Replace(old, "\r\n", "\n", -1), "\n")
+	b := strings.Split(strings.Replace(new, "\r\n", "\n", -1), "\n")
+
+	// same head and tail
+	head := 0
+	for head < len(a) && head < len(b) && a[head] == b[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(a)-head && tail < len(b)-head && a[len(a)-1-tail] == b[len(b)-1-tail] {
+		tail++
+	}
+	for _, l := range a[:head] {
+		diff = append(diff, DiffLine{" ", l})
+	}
+	diff = append(diff, lcsDiff(a[head:len(a)-tail], b[head:len(b)-tail])...)
+	for _, l := range a[len(a)-tail:] {
+		diff = append(diff, DiffLine{" ", l})
+	}
+	return diff
+}
+
+// lcsDiff diff lines by longest common subsequence
+func lcsDiff(a, b []string) (diff []DiffLine) {
+	n, m := len(a), len(b)
+	if n*m > diffMax {
+		for _, l := range a {
+			diff = append(diff, DiffLine{"-", l})
+		}
+		for _, l := range b {
+			diff = append(diff, DiffLine{"+", l})
+		}
+		return diff
+	}
+	// lcs[i][j] - lcs length of a[i:] and b[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{" ", a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{"-", a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{"+", b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		diff = append(diff, DiffLine{"-", a[i]})
+	}
+	for ; j < m; j++ {
+		diff = append(diff, DiffLine{"+", b[j]})
+	}
+	return diff
+}